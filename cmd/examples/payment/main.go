@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -22,21 +23,64 @@ type PaymentService struct {
 	conn       *grpc.ClientConn
 }
 
-func (p *PaymentService) checkLimit(svc, path, ip string, hdrs map[string]string) (bool, int32, int64, error) {
+// forwardingHeaders are passed to the limiter so it can find the client
+// address behind trusted proxies.
+var forwardingHeaders = []string{"X-Forwarded-For", "X-Real-IP", "Forwarded"}
+
+func (p *PaymentService) checkLimit(svc, path string, r *http.Request, hdrs map[string]string) (*pb.CheckResponse, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
 
+	headers := make(map[string]string, len(hdrs)+len(forwardingHeaders))
+	for k, v := range hdrs {
+		headers[k] = v
+	}
+	for _, name := range forwardingHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+
+	query := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		query[k] = v[0]
+	}
+
 	resp, err := p.grpcClient.Check(ctx, &pb.CheckRequest{
-		Service: svc,
-		Api:     path,
-		Ip:      ip,
-		Headers: hdrs,
+		Service:  svc,
+		Api:      path,
+		Method:   r.Method,
+		PeerAddr: r.RemoteAddr,
+		Headers:  headers,
+		Query:    query,
 	})
 	if err != nil {
-		return false, 0, 0, err
+		return nil, err
+	}
+	if resp.Degraded {
+		slog.Warn("rate limiter store unavailable, decision made by failure policy", "allowed", resp.Allowed)
+	}
+
+	if resp.Allowed && resp.DelayMs > 0 {
+		time.Sleep(time.Duration(resp.DelayMs) * time.Millisecond)
 	}
 
-	return resp.Allowed, resp.Remaining, resp.ResetAt, nil
+	return resp, nil
+}
+
+func setLimitHeaders(w http.ResponseWriter, resp *pb.CheckResponse) {
+	if resp.Limit <= 0 {
+		return
+	}
+
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(resp.Remaining)))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(int(resp.Limit)))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(resp.Remaining)))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(max(resp.ResetAt-time.Now().Unix(), 0), 10))
+
+	if !resp.Allowed && resp.RetryAfterMs >= 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt((resp.RetryAfterMs+999)/1000, 10))
+	}
 }
 
 func (p *PaymentService) handleProcess(w http.ResponseWriter, r *http.Request) {
@@ -48,21 +92,21 @@ func (p *PaymentService) handleProcess(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("processing payment", "session_id", sessionID, "ip", r.RemoteAddr)
 
-	allowed, remaining, _, err := p.checkLimit("payment-service", "/payment/process", r.RemoteAddr, map[string]string{"X-Session-ID": sessionID})
+	limit, err := p.checkLimit("payment-service", "/payment/process", r, map[string]string{"X-Session-ID": sessionID})
 	if err != nil {
 		slog.Error("rate limit check failed", "error", err, "session_id", sessionID)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	setLimitHeaders(w, limit)
 
-	if !allowed {
-		slog.Warn("rate limit exceeded", "session_id", sessionID, "remaining", remaining)
-		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+	if !limit.Allowed {
+		slog.Warn("rate limit exceeded", "session_id", sessionID, "remaining", limit.Remaining, "retry_after_ms", limit.RetryAfterMs)
 		http.Error(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
 
-	slog.Info("payment processed successfully", "session_id", sessionID, "remaining", remaining)
+	slog.Info("payment processed successfully", "session_id", sessionID, "remaining", limit.Remaining)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status": "success",
@@ -73,26 +117,22 @@ func (p *PaymentService) handleProcess(w http.ResponseWriter, r *http.Request) {
 
 func (p *PaymentService) handleValidate(w http.ResponseWriter, r *http.Request) {
 	ip := r.RemoteAddr
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		ip = forwarded
-	}
 
-	allowed, remaining, _, err := p.checkLimit("payment-service", "/payment/validate", ip, nil)
+	limit, err := p.checkLimit("payment-service", "/payment/validate", r, nil)
 	if err != nil {
 		slog.Error("rate limit check failed", "error", err, "ip", ip)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
+	setLimitHeaders(w, limit)
 
-	if !allowed {
-		slog.Warn("validation rate limited", "ip", ip, "remaining", remaining)
-		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+	if !limit.Allowed {
+		slog.Warn("validation rate limited", "ip", ip, "remaining", limit.Remaining, "retry_after_ms", limit.RetryAfterMs)
 		http.Error(w, "rate limited", http.StatusTooManyRequests)
 		return
 	}
 
-	slog.Debug("validation successful", "ip", ip, "remaining", remaining)
-	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+	slog.Debug("validation successful", "ip", ip, "remaining", limit.Remaining)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{
 		"valid": true,
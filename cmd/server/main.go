@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
@@ -36,15 +38,42 @@ func main() {
 		os.Exit(1)
 	}
 
-	slog.Info("connecting to redis", "addr", cfg.Redis.Addr)
-	store, err := storage.NewRedis(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB, cfg.Redis.PoolSize)
+	var store storage.Backend
+	if cfg.Storage.Backend == config.BackendMemory {
+		slog.Info("using in-memory storage", "max_keys", cfg.Storage.MaxKeys)
+		store = storage.NewMemory(cfg.Storage.MaxKeys)
+	} else {
+		slog.Info("connecting to redis", "addr", cfg.Redis.Addr)
+		store, err = storage.NewRedis(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB, cfg.Redis.PoolSize)
+		if err != nil {
+			slog.Error("redis connection failed", "error", err)
+			os.Exit(1)
+		}
+	}
+	defer store.Close()
+
+	rl := limiter.New(store)
+	rl.SetFailurePolicy(cfg.Failure)
+	rl.SetUnmatchedPolicy(cfg.Unmatched)
+	rl.SetClientIP(cfg.ClientIP)
+	rl.SetRefund(cfg.Refund)
+	jwt, err := limiter.NewJWTVerifier(cfg.JWT)
 	if err != nil {
-		slog.Error("redis connection failed", "error", err)
+		slog.Error("jwt setup failed", "error", err)
 		os.Exit(1)
 	}
-	defer store.Close()
+	rl.SetJWT(jwt)
 
-	rl := limiter.NewRedis(store)
+	if len(cfg.Services) > 0 {
+		slog.Info("seeding services from config", "services", len(cfg.Services), "on_conflict", cfg.Seed.OnConflict)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := rl.Reconcile(ctx, nil, cfg.Services, cfg.Seed.OnConflict)
+		cancel()
+		if err != nil {
+			slog.Error("service seeding failed", "error", err)
+			os.Exit(1)
+		}
+	}
 
 	grpcAddr := cfg.GRPC.Addr
 	slog.Info("starting grpc server", "addr", grpcAddr)
@@ -72,11 +101,48 @@ func main() {
 
 	slog.Info("grpc server ready for service registration")
 
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+
+	current := cfg
+	watcher := config.NewWatcher(configPath, time.Duration(cfg.Reload.IntervalSeconds)*time.Second)
+	go watcher.Run(watchCtx, func(next *config.Config) error {
+		if next.Storage != current.Storage || next.Redis != current.Redis || next.GRPC != current.GRPC {
+			slog.Warn("storage, redis or grpc settings changed, restart required to apply them")
+		}
+		jwt, err := limiter.NewJWTVerifier(next.JWT)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(watchCtx, 10*time.Second)
+		defer cancel()
+		if err := rl.Reconcile(ctx, current.Services, next.Services, next.Seed.OnConflict); err != nil {
+			return err
+		}
+		rl.SetFailurePolicy(next.Failure)
+		rl.SetUnmatchedPolicy(next.Unmatched)
+		rl.SetClientIP(next.ClientIP)
+		rl.SetRefund(next.Refund)
+		rl.SetJWT(jwt)
+		current = next
+		return nil
+	})
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("received SIGHUP, reloading config")
+			watcher.Trigger()
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	slog.Info("shutting down gracefully")
+	stopWatch()
 	s.GracefulStop()
 	slog.Info("shutdown complete")
 }
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+const (
+	HeaderXForwardedFor = "x-forwarded-for"
+	HeaderXRealIP       = "x-real-ip"
+	HeaderForwarded     = "forwarded"
+)
+
+// ClientIPConfig tells the limiter how to find the client address of a
+// request that carries its peer address. Only peers within TrustedProxies
+// (CIDRs or single addresses) are believed about the address they forwarded
+// for, which they report in Header.
+type ClientIPConfig struct {
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	Header         string   `yaml:"header"`
+}
+
+// Prefixes parses TrustedProxies; single addresses become one-address
+// prefixes.
+func (c ClientIPConfig) Prefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, s := range c.TrustedProxies {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad trusted proxy %s", s)
+			}
+			s = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()).String()
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted proxy %s", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
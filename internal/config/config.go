@@ -3,14 +3,70 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+)
+
+var algorithms = map[string]bool{
+	"sliding_window": true,
+	"token_bucket":   true,
+	"fixed_window":   true,
+	"gcra":           true,
+	"leaky_bucket":   true,
+	"concurrency":    true,
+
+	"sliding_window_counter": true,
+}
+
+func ValidAlgorithm(name string) bool {
+	return algorithms[name]
+}
+
+const (
+	SeedOverwrite = "overwrite"
+	SeedKeep      = "keep"
+	SeedFail      = "fail"
+)
+
+const (
+	FailOpen   = "open"
+	FailClosed = "closed"
+	FailLocal  = "local"
+)
+
+const (
+	UnmatchedAllow   = "allow"
+	UnmatchedDeny    = "deny"
+	UnmatchedDefault = "default"
+)
+
 type Config struct {
-	Redis    RedisConfig `yaml:"redis"`
-	GRPC     GRPCConfig  `yaml:"grpc"`
-	Services []Service   `yaml:"services"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Redis     RedisConfig     `yaml:"redis"`
+	GRPC      GRPCConfig      `yaml:"grpc"`
+	Seed      SeedConfig      `yaml:"seed"`
+	Reload    ReloadConfig    `yaml:"reload"`
+	Failure   FailureConfig   `yaml:"failure"`
+	Unmatched UnmatchedConfig `yaml:"unmatched"`
+	ClientIP  ClientIPConfig  `yaml:"client_ip"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	Refund    RefundConfig    `yaml:"refund"`
+	Services  []Service       `yaml:"services"`
+}
+
+// StorageConfig selects where limiter state lives. The memory backend keeps
+// everything in process and is only suitable for a single limiter instance.
+type StorageConfig struct {
+	Backend string `yaml:"backend"`
+	MaxKeys int    `yaml:"max_keys"`
 }
 
 type RedisConfig struct {
@@ -24,18 +80,101 @@ type GRPCConfig struct {
 	Addr string `yaml:"addr"`
 }
 
+// SeedConfig controls how services declared in the config file are written
+// to the store on startup when the store already holds a different version.
+type SeedConfig struct {
+	OnConflict string `yaml:"on_conflict"`
+}
+
+// ReloadConfig controls how often the config file is polled for changes.
+// Services are reloaded on change or SIGHUP; storage, redis and grpc settings
+// still require a restart.
+type ReloadConfig struct {
+	IntervalSeconds int `yaml:"interval_seconds"`
+}
+
+// FailureConfig decides how requests are answered while the store is
+// unavailable: allowed (open), rejected (closed), or checked against an
+// in-process copy of the service's rules (local). Policy is the default and
+// Services overrides it by service name.
+type FailureConfig struct {
+	Policy   string            `yaml:"policy"`
+	Services map[string]string `yaml:"services"`
+}
+
+// PolicyFor returns the failure policy of a service.
+func (f FailureConfig) PolicyFor(service string) string {
+	if p, ok := f.Services[service]; ok {
+		return p
+	}
+	return f.Policy
+}
+
+func validFailurePolicy(p string) bool {
+	return p == FailOpen || p == FailClosed || p == FailLocal
+}
+
+// UnmatchedConfig decides how requests without a rule are answered, because
+// their service is not registered or no API of it matches: allowed, denied,
+// or checked against Rule (default). The default rule counts each path
+// separately and cannot be a concurrency rule.
+type UnmatchedConfig struct {
+	Policy string `yaml:"policy"`
+	Rule   *API   `yaml:"rule"`
+}
+
+// DefaultReservationTTL is how long a refundable request can be refunded
+// unless refund.reservation_ttl says otherwise.
+const DefaultReservationTTL = time.Minute
+
+// RefundConfig controls the reservations kept for requests that ask to be
+// refundable. A reservation also never outlives the state of its rules.
+type RefundConfig struct {
+	ReservationTTL time.Duration `yaml:"reservation_ttl"`
+}
+
 type Service struct {
 	Name string `yaml:"name"`
 	APIs []API  `yaml:"apis"`
 }
 
+// API is one rule. The window is given either as window_seconds or, for
+// sub-second limits, as a duration in window (e.g. 500ms). Path is a route
+// template; KeyPath selects whether requests are counted per template (the
+// default) or per concrete path. A rule with Methods only applies to those
+// HTTP methods and takes precedence over method-agnostic rules on its path.
 type API struct {
-	Path          string `yaml:"path"`
-	Algorithm     string `yaml:"algorithm"`
-	KeyStrategy   string `yaml:"key_strategy"`
-	Limit         int    `yaml:"limit"`
-	WindowSeconds int    `yaml:"window_seconds"`
-	Burst         int    `yaml:"burst"`
+	Path          string        `yaml:"path"`
+	Methods       []string      `yaml:"methods"`
+	KeyPath       string        `yaml:"key_path"`
+	Algorithm     string        `yaml:"algorithm"`
+	KeyStrategy   KeyStrategy   `yaml:"key_strategy"`
+	Limit         int           `yaml:"limit"`
+	WindowSeconds int           `yaml:"window_seconds"`
+	Window        time.Duration `yaml:"window"`
+	Burst         int           `yaml:"burst"`
+}
+
+// WindowMs returns the rule's window in milliseconds.
+func (a API) WindowMs() int64 {
+	if a.Window != 0 {
+		return a.Window.Milliseconds()
+	}
+	return int64(a.WindowSeconds) * 1000
+}
+
+// CheckWindow validates the window of a single rule.
+func CheckWindow(api API) error {
+	if api.Window != 0 && api.WindowSeconds != 0 {
+		return fmt.Errorf("set window or window_seconds, not both")
+	}
+	if api.Window%time.Millisecond != 0 {
+		return fmt.Errorf("window must be a whole number of milliseconds: %s", api.Window)
+	}
+	if api.WindowMs() <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	return nil
 }
 
 func Load(path string) (*Config, error) {
@@ -43,7 +182,10 @@ func Load(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	return Parse(data)
+}
 
+func Parse(data []byte) (*Config, error) {
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
@@ -57,43 +199,187 @@ func Load(path string) (*Config, error) {
 }
 
 func (c *Config) validate() error {
-	if c.Redis.Addr == "" {
+	switch c.Storage.Backend {
+	case "":
+		c.Storage.Backend = BackendRedis
+	case BackendRedis, BackendMemory:
+	default:
+		return fmt.Errorf("bad storage backend: %s", c.Storage.Backend)
+	}
+	if c.Storage.Backend == BackendRedis && c.Redis.Addr == "" {
 		return fmt.Errorf("redis addr required")
 	}
+	if c.Storage.MaxKeys < 0 {
+		return fmt.Errorf("bad storage max_keys: %d", c.Storage.MaxKeys)
+	}
 	if c.GRPC.Addr == "" {
 		return fmt.Errorf("grpc addr required")
 	}
 
+	switch c.Seed.OnConflict {
+	case "":
+		c.Seed.OnConflict = SeedOverwrite
+	case SeedOverwrite, SeedKeep, SeedFail:
+	default:
+		return fmt.Errorf("bad seed on_conflict: %s", c.Seed.OnConflict)
+	}
+
+	if c.Reload.IntervalSeconds < 0 {
+		return fmt.Errorf("bad reload interval: %d", c.Reload.IntervalSeconds)
+	}
+	if c.Reload.IntervalSeconds == 0 {
+		c.Reload.IntervalSeconds = 5
+	}
+
+	if c.Failure.Policy == "" {
+		c.Failure.Policy = FailOpen
+	}
+	if !validFailurePolicy(c.Failure.Policy) {
+		return fmt.Errorf("bad failure policy: %s", c.Failure.Policy)
+	}
+	for name, p := range c.Failure.Services {
+		if !validFailurePolicy(p) {
+			return fmt.Errorf("bad failure policy for service %s: %s", name, p)
+		}
+	}
+
+	switch c.Unmatched.Policy {
+	case "":
+		c.Unmatched.Policy = UnmatchedAllow
+	case UnmatchedAllow, UnmatchedDeny:
+	case UnmatchedDefault:
+		if err := checkDefaultRule(c.Unmatched.Rule); err != nil {
+			return fmt.Errorf("unmatched rule: %w", err)
+		}
+	default:
+		return fmt.Errorf("bad unmatched policy: %s", c.Unmatched.Policy)
+	}
+
+	switch c.ClientIP.Header {
+	case "":
+		c.ClientIP.Header = HeaderXForwardedFor
+	case HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded:
+	default:
+		return fmt.Errorf("bad client_ip header: %s", c.ClientIP.Header)
+	}
+	if _, err := c.ClientIP.Prefixes(); err != nil {
+		return err
+	}
+	if err := c.JWT.validate(); err != nil {
+		return err
+	}
+
+	switch {
+	case c.Refund.ReservationTTL == 0:
+		c.Refund.ReservationTTL = DefaultReservationTTL
+	case c.Refund.ReservationTTL < time.Millisecond:
+		return fmt.Errorf("bad refund reservation_ttl: %s", c.Refund.ReservationTTL)
+	}
+
 	if len(c.Services) == 0 {
 		return nil
 	}
 
+	seen := make(map[string]bool)
 	for _, svc := range c.Services {
 		if svc.Name == "" {
 			return fmt.Errorf("empty service name")
 		}
+		if seen[svc.Name] {
+			return fmt.Errorf("duplicate service %s", svc.Name)
+		}
+		seen[svc.Name] = true
 		if len(svc.APIs) == 0 {
 			return fmt.Errorf("service %s needs at least one API", svc.Name)
 		}
 
 		for _, api := range svc.APIs {
-			if api.Path == "" {
-				return fmt.Errorf("service %s has empty path", svc.Name)
+			if err := CheckPath(api); err != nil {
+				return fmt.Errorf("service %s: %w", svc.Name, err)
 			}
-			if api.Algorithm != "sliding_window" && api.Algorithm != "token_bucket" {
+			if !ValidAlgorithm(api.Algorithm) {
 				return fmt.Errorf("service %s api %s bad algorithm: %s", svc.Name, api.Path, api.Algorithm)
 			}
 			if api.Limit <= 0 {
 				return fmt.Errorf("service %s api %s bad limit: %d", svc.Name, api.Path, api.Limit)
 			}
-			if api.WindowSeconds <= 0 {
-				return fmt.Errorf("service %s api %s bad window: %d", svc.Name, api.Path, api.WindowSeconds)
+			if err := CheckWindow(api); err != nil {
+				return fmt.Errorf("service %s api %s: %w", svc.Name, api.Path, err)
 			}
-			if api.KeyStrategy == "" {
-				return fmt.Errorf("service %s api %s missing key strategy", svc.Name, api.Path)
+			if err := CheckKeyStrategy(api.KeyStrategy); err != nil {
+				return fmt.Errorf("service %s api %s: %w", svc.Name, api.Path, err)
 			}
 		}
+
+		if err := CheckRules(svc.APIs); err != nil {
+			return fmt.Errorf("service %s: %w", svc.Name, err)
+		}
 	}
 
 	return nil
 }
+
+func checkDefaultRule(rule *API) error {
+	if rule == nil {
+		return fmt.Errorf("missing")
+	}
+	if !ValidAlgorithm(rule.Algorithm) || rule.Algorithm == "concurrency" {
+		return fmt.Errorf("bad algorithm: %s", rule.Algorithm)
+	}
+	if rule.Limit <= 0 {
+		return fmt.Errorf("bad limit: %d", rule.Limit)
+	}
+	if err := CheckKeyStrategy(rule.KeyStrategy); err != nil {
+		return err
+	}
+	return CheckWindow(*rule)
+}
+
+// CheckRules validates how the rules of one service combine. A path may have
+// several rules, which are all enforced together, but a concurrency rule must
+// be the only rule on its path and two rules sharing a counter would count
+// every request twice against the same key. Templates differing only in parameter names are
+// rejected, since neither would be more specific.
+func CheckRules(apis []API) error {
+	perPath := make(map[string][]API)
+	shapes := make(map[string]string)
+	for _, api := range apis {
+		shape := pathShape(api.Path)
+		if other, ok := shapes[shape]; ok && other != api.Path {
+			return fmt.Errorf("apis %s and %s match the same paths", other, api.Path)
+		}
+		shapes[shape] = api.Path
+
+		for _, other := range perPath[api.Path] {
+			if sameCounter(other, api) {
+				return fmt.Errorf("api %s has two %s rules with the same window, methods and key", api.Path, api.Algorithm)
+			}
+		}
+		perPath[api.Path] = append(perPath[api.Path], api)
+	}
+
+	for path, rules := range perPath {
+		if len(rules) == 1 {
+			continue
+		}
+		for _, api := range rules {
+			if api.Algorithm == "concurrency" {
+				return fmt.Errorf("api %s mixes concurrency with other rules", path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sameCounter reports whether two rules on one path would be counted under
+// the same key. A rule's counter is identified by its methods, key strategy,
+// algorithm, window and effective key_path, not by its limit or burst, so
+// that tuning those keeps the current counts.
+func sameCounter(a, b API) bool {
+	return slices.EqualFunc(a.Methods, b.Methods, strings.EqualFold) &&
+		a.KeyStrategy == b.KeyStrategy &&
+		a.Algorithm == b.Algorithm &&
+		a.WindowMs() == b.WindowMs() &&
+		a.EffectiveKeyPath() == b.EffectiveKeyPath()
+}
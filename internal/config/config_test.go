@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckRulesSharedCounter(t *testing.T) {
+	rule := func(path, keyPath string) API {
+		return API{Path: path, KeyPath: keyPath, Algorithm: "fixed_window", KeyStrategy: "ip", Limit: 10, WindowSeconds: 60}
+	}
+
+	tests := []struct {
+		name string
+		a, b API
+		dup  bool
+	}{
+		{"unset and template", rule("/users/{id}", ""), rule("/users/{id}", KeyPathTemplate), true},
+		{"concrete on a literal path", rule("/users", KeyPathConcrete), rule("/users", ""), true},
+		{"concrete on a template", rule("/users/{id}", KeyPathConcrete), rule("/users/{id}", ""), false},
+		{"limits differ", rule("/users", ""), API{Path: "/users", Algorithm: "fixed_window", KeyStrategy: "ip", Limit: 20, Window: time.Minute}, true},
+		{"windows differ", rule("/users", ""), API{Path: "/users", Algorithm: "fixed_window", KeyStrategy: "ip", Limit: 20, WindowSeconds: 3600}, false},
+		{"methods differ", rule("/users", ""), API{Path: "/users", Methods: []string{"get"}, Algorithm: "fixed_window", KeyStrategy: "ip", Limit: 10, WindowSeconds: 60}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckRules([]API{tt.a, tt.b})
+			if (err != nil) != tt.dup {
+				t.Errorf("CheckRules = %v, want shared counter %v", err, tt.dup)
+			}
+		})
+	}
+}
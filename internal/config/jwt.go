@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// JWTConfig configures the jwt:<claim> key strategy. Bearer tokens must be
+// signed with one of HMACSecrets (HS256/384/512) or a key in the JWKS file
+// (RS and ES algorithms); requests whose token does not verify, or lacks the
+// claim, are keyed by Fallback instead.
+type JWTConfig struct {
+	HMACSecrets []string    `yaml:"hmac_secrets"`
+	JWKSFile    string      `yaml:"jwks_file"`
+	Fallback    KeyStrategy `yaml:"fallback"`
+}
+
+func (j *JWTConfig) validate() error {
+	if j.Fallback == "" {
+		j.Fallback = "ip"
+	}
+	if err := CheckKeyStrategy(j.Fallback); err != nil {
+		return fmt.Errorf("jwt fallback: %w", err)
+	}
+	for _, part := range j.Fallback.Parts() {
+		if strings.HasPrefix(part, "jwt:") {
+			return fmt.Errorf("jwt fallback cannot use jwt")
+		}
+	}
+	for _, s := range j.HMACSecrets {
+		if s == "" {
+			return fmt.Errorf("empty jwt hmac secret")
+		}
+	}
+	return nil
+}
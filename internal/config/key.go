@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KeyStrategy says which request attributes a rule counts by. It is one or
+// more parts joined by "+", e.g. "ip+header:X-Tenant"; in YAML it may also be
+// written as a list of parts. The ip part takes optional prefix lengths that
+// aggregate addresses into networks, see IPMasks.
+type KeyStrategy string
+
+// keyParts lists the kinds of key strategy parts and whether they take an
+// argument, written kind:argument.
+var keyParts = map[string]bool{
+	"ip":      false,
+	"header":  true,
+	"jwt":     true,
+	"query":   true,
+	"attr":    true,
+	"default": false,
+}
+
+func (k *KeyStrategy) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		var parts []string
+		if err := node.Decode(&parts); err != nil {
+			return err
+		}
+		*k = KeyStrategy(strings.Join(parts, "+"))
+		return nil
+	}
+
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	*k = KeyStrategy(s)
+	return nil
+}
+
+func (k KeyStrategy) Parts() []string {
+	return strings.Split(string(k), "+")
+}
+
+// CheckKeyStrategy rejects unknown or malformed parts. The shared default
+// key cannot be combined with other parts.
+func CheckKeyStrategy(k KeyStrategy) error {
+	if k == "" {
+		return fmt.Errorf("missing key strategy")
+	}
+
+	parts := k.Parts()
+	seen := make(map[string]bool, len(parts))
+	for _, part := range parts {
+		kind, arg, hasArg := strings.Cut(part, ":")
+		kind, masks, hasMasks := strings.Cut(kind, "/")
+		takesArg, ok := keyParts[kind]
+		if !ok {
+			return fmt.Errorf("unknown key strategy %q", part)
+		}
+		if takesArg != hasArg || hasArg && (arg == "" || strings.ContainsAny(arg, ":+ ")) {
+			return fmt.Errorf("bad key strategy %q", part)
+		}
+		if hasMasks {
+			if _, _, err := IPMasks(masks); kind != "ip" || masks == "" || err != nil {
+				return fmt.Errorf("bad key strategy %q", part)
+			}
+		}
+		if kind == "default" && len(parts) > 1 {
+			return fmt.Errorf("key strategy default cannot be combined")
+		}
+		if seen[part] {
+			return fmt.Errorf("key strategy %q repeats %s", k, part)
+		}
+		seen[part] = true
+	}
+	return nil
+}
+
+// DefaultIPv6Mask is the IPv6 prefix length of an ip part that only gives
+// an IPv4 one, so that callers rotating through the addresses of one IPv6
+// network still share a counter.
+const DefaultIPv6Mask = 64
+
+// IPMasks returns the IPv4 and IPv6 prefix lengths of an ip part's masks:
+// "" keeps whole addresses, a single length up to 32 applies to IPv4 with
+// IPv6 grouped by DefaultIPv6Mask, a single longer length applies to IPv6
+// only, and "v4/v6" gives both.
+func IPMasks(masks string) (v4, v6 int, err error) {
+	if masks == "" {
+		return 32, 128, nil
+	}
+
+	first, second, hasSecond := strings.Cut(masks, "/")
+	n, err := strconv.Atoi(first)
+	if err != nil || n < 1 || n > 128 || hasSecond && n > 32 {
+		return 0, 0, fmt.Errorf("bad ip mask %q", masks)
+	}
+	if !hasSecond {
+		if n > 32 {
+			return 32, n, nil
+		}
+		return n, DefaultIPv6Mask, nil
+	}
+	m, err := strconv.Atoi(second)
+	if err != nil || m < 1 || m > 128 {
+		return 0, 0, fmt.Errorf("bad ip mask %q", masks)
+	}
+	return n, m, nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Paths are route templates. A segment is a literal, {name} or * matching
+// exactly one segment, or ** matching any number of remaining segments,
+// which is only allowed as the last segment.
+const (
+	KeyPathTemplate = "template"
+	KeyPathConcrete = "concrete"
+)
+
+// CheckPath validates how a rule matches requests, by path template and
+// methods, and how it keys them.
+func CheckPath(api API) error {
+	if api.Path == "" {
+		return fmt.Errorf("path cannot be empty")
+	}
+	if !strings.HasPrefix(api.Path, "/") {
+		return fmt.Errorf("path %s must start with /", api.Path)
+	}
+
+	segments := strings.Split(api.Path, "/")
+	for i, seg := range segments {
+		switch {
+		case seg == "**":
+			if i != len(segments)-1 {
+				return fmt.Errorf("path %s: ** must be the last segment", api.Path)
+			}
+		case seg == "*":
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			if name := seg[1 : len(seg)-1]; name == "" || strings.ContainsAny(name, "{}*") {
+				return fmt.Errorf("path %s: bad parameter %s", api.Path, seg)
+			}
+		case strings.ContainsAny(seg, "{}*"):
+			return fmt.Errorf("path %s: wildcards must span a whole segment", api.Path)
+		}
+	}
+
+	for _, m := range api.Methods {
+		if m == "" || strings.ContainsFunc(m, func(r rune) bool { return !unicode.IsLetter(r) }) {
+			return fmt.Errorf("path %s: bad method %q", api.Path, m)
+		}
+	}
+
+	switch api.KeyPath {
+	case "", KeyPathTemplate, KeyPathConcrete:
+	default:
+		return fmt.Errorf("bad key_path: %s", api.KeyPath)
+	}
+	return nil
+}
+
+// EffectiveKeyPath returns how the rule keys requests. An unset key_path
+// means template, and concrete on a path without wildcards is the same as
+// template, since such a path only matches itself.
+func (a API) EffectiveKeyPath() string {
+	if a.KeyPath != KeyPathConcrete || !strings.ContainsAny(a.Path, "{*") {
+		return KeyPathTemplate
+	}
+	return KeyPathConcrete
+}
+
+// pathShape is the template with parameter names erased, so templates that
+// match exactly the same paths compare equal.
+func pathShape(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, "{") {
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, "/")
+}
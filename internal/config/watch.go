@@ -0,0 +1,79 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Watcher polls a config file and hands every valid new version to a
+// callback. Invalid edits are logged and ignored, leaving the previously
+// applied config in force. A version the callback fails to apply is retried
+// on every poll until it succeeds or the file changes.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	trigger  chan struct{}
+	last     []byte
+	invalid  []byte
+}
+
+func NewWatcher(path string, interval time.Duration) *Watcher {
+	return &Watcher{
+		path:     path,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Trigger forces a reload on the next iteration even if the file contents
+// did not change.
+func (w *Watcher) Trigger() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Watcher) Run(ctx context.Context, apply func(*Config) error) {
+	w.last, _ = os.ReadFile(w.path)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		forced := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.trigger:
+			forced = true
+		}
+
+		data, err := os.ReadFile(w.path)
+		if err != nil {
+			slog.Error("config reload failed", "path", w.path, "error", err)
+			continue
+		}
+		if !forced && (bytes.Equal(data, w.last) || bytes.Equal(data, w.invalid)) {
+			continue
+		}
+
+		slog.Info("reloading config", "path", w.path, "forced", forced)
+		cfg, err := Parse(data)
+		if err != nil {
+			w.invalid = data
+			slog.Error("config reload rejected, keeping previous config", "error", err)
+			continue
+		}
+		if err := apply(cfg); err != nil {
+			slog.Error("config reload failed, keeping previous config and retrying", "error", err)
+			continue
+		}
+		w.last = data
+		slog.Info("config reloaded", "services", len(cfg.Services))
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const watchBase = "redis:\n  addr: a\ngrpc:\n  addr: b\n"
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startWatcher runs a Watcher on a temp file holding watchBase and returns
+// the file path once the watcher has applied it. Every config handed to apply
+// is then sent on the returned channel.
+func startWatcher(t *testing.T, apply func(*Config) error) (string, <-chan *Config) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, watchBase)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	applied := make(chan *Config, 16)
+	w := NewWatcher(path, 10*time.Millisecond)
+	go w.Run(ctx, func(c *Config) error {
+		err := apply(c)
+		applied <- c
+		return err
+	})
+
+	// A forced reload makes sure Run has read the initial file before the
+	// test edits it.
+	w.Trigger()
+	waitApplied(t, applied)
+	return path, applied
+}
+
+func waitApplied(t *testing.T, applied <-chan *Config) *Config {
+	t.Helper()
+	select {
+	case c := <-applied:
+		return c
+	case <-time.After(2 * time.Second):
+		t.Fatal("config not applied")
+		return nil
+	}
+}
+
+func expectNoApply(t *testing.T, applied <-chan *Config) {
+	t.Helper()
+	select {
+	case c := <-applied:
+		t.Fatalf("unexpected apply: %+v", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatcherRejectsInvalidEdit(t *testing.T) {
+	path, applied := startWatcher(t, func(*Config) error { return nil })
+
+	writeConfig(t, path, watchBase+"seed:\n  on_conflict: nope\n")
+	expectNoApply(t, applied)
+
+	writeConfig(t, path, watchBase+"seed:\n  on_conflict: keep\n")
+	if c := waitApplied(t, applied); c.Seed.OnConflict != SeedKeep {
+		t.Errorf("applied on_conflict %q, want %q", c.Seed.OnConflict, SeedKeep)
+	}
+	expectNoApply(t, applied)
+}
+
+func TestWatcherRetriesFailedApply(t *testing.T) {
+	failures := 2
+	path, applied := startWatcher(t, func(*Config) error {
+		if failures > 0 {
+			failures--
+			return errors.New("store unavailable")
+		}
+		return nil
+	})
+
+	// The initial forced reload used up one failure, the edit fails once
+	// more and then applies on the next poll.
+	writeConfig(t, path, watchBase+"seed:\n  on_conflict: keep\n")
+	for i := 0; i < 2; i++ {
+		if c := waitApplied(t, applied); c.Seed.OnConflict != SeedKeep {
+			t.Fatalf("attempt %d applied on_conflict %q", i, c.Seed.OnConflict)
+		}
+	}
+	expectNoApply(t, applied)
+}
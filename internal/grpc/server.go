@@ -2,7 +2,12 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/larrasket/hlimiter/internal/config"
 	"github.com/larrasket/hlimiter/internal/limiter"
@@ -11,10 +16,10 @@ import (
 
 type Server struct {
 	pb.UnimplementedRateLimiterServer
-	limiter *limiter.RedisRateLimiter
+	limiter limiter.Limiter
 }
 
-func NewServer(rl *limiter.RedisRateLimiter) *Server {
+func NewServer(rl limiter.Limiter) *Server {
 	return &Server{limiter: rl}
 }
 
@@ -23,10 +28,13 @@ func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.Reg
 	for _, apiCfg := range req.Apis {
 		apis = append(apis, config.API{
 			Path:          apiCfg.Path,
+			Methods:       apiCfg.Methods,
+			KeyPath:       apiCfg.KeyPath,
 			Algorithm:     apiCfg.Algorithm,
-			KeyStrategy:   apiCfg.KeyStrategy,
+			KeyStrategy:   config.KeyStrategy(apiCfg.KeyStrategy),
 			Limit:         int(apiCfg.Limit),
 			WindowSeconds: int(apiCfg.WindowSeconds),
+			Window:        time.Duration(apiCfg.WindowMs) * time.Millisecond,
 			Burst:         int(apiCfg.Burst),
 		})
 	}
@@ -45,21 +53,97 @@ func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.Reg
 }
 
 func (s *Server) Check(ctx context.Context, req *pb.CheckRequest) (*pb.CheckResponse, error) {
-	limReq := limiter.CheckRequest{
+	resp, err := s.limiter.Check(ctx, checkRequest(req))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return checkResponse(resp), nil
+}
+
+func (s *Server) Peek(ctx context.Context, req *pb.CheckRequest) (*pb.CheckResponse, error) {
+	resp, err := s.limiter.Peek(ctx, checkRequest(req))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return checkResponse(resp), nil
+}
+
+func checkRequest(req *pb.CheckRequest) limiter.CheckRequest {
+	return limiter.CheckRequest{
 		Service: req.Service,
 		API:     req.Api,
+		Method:  req.Method,
 		IP:      req.Ip,
 		Headers: req.Headers,
-	}
+		Cost:    int(req.Cost),
 
-	resp, err := s.limiter.Check(ctx, limReq)
-	if err != nil {
-		return nil, err
+		PeerAddr:   req.PeerAddr,
+		Query:      req.Query,
+		Attributes: req.Attributes,
+		Refundable: req.Refundable,
 	}
+}
 
+func checkResponse(resp limiter.CheckResponse) *pb.CheckResponse {
 	return &pb.CheckResponse{
 		Allowed:   resp.Allowed,
 		Remaining: int32(resp.Remaining),
 		ResetAt:   resp.ResetAt,
+		DelayMs:   resp.DelayMs,
+
+		RetryAfterMs:  resp.RetryAfterMs,
+		ReservationId: resp.ReservationID,
+		Limit:         int32(resp.Limit),
+		Path:          resp.Path,
+		Algorithm:     resp.Algorithm,
+		KeyStrategy:   resp.KeyStrategy,
+		Reason:        resp.Reason,
+		Degraded:      resp.Degraded,
+	}
+}
+
+func (s *Server) Refund(ctx context.Context, req *pb.RefundRequest) (*pb.RefundResponse, error) {
+	refunded, err := s.limiter.Refund(ctx, req.ReservationId, int(req.Units))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.RefundResponse{Refunded: int32(refunded)}, nil
+}
+
+func (s *Server) Acquire(ctx context.Context, req *pb.CheckRequest) (*pb.AcquireResponse, error) {
+	resp, err := s.limiter.Acquire(ctx, checkRequest(req))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.AcquireResponse{
+		Allowed:   resp.Allowed,
+		Remaining: int32(resp.Remaining),
+		LeaseId:   resp.LeaseID,
+		ExpiresAt: resp.ExpiresAt,
+		Reason:    resp.Reason,
+		Degraded:  resp.Degraded,
 	}, nil
 }
+
+func (s *Server) Release(ctx context.Context, req *pb.ReleaseRequest) (*pb.ReleaseResponse, error) {
+	released, err := s.limiter.Release(ctx, req.LeaseId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.ReleaseResponse{Released: released}, nil
+}
+
+func toStatus(err error) error {
+	if errors.Is(err, limiter.ErrAlgorithmMismatch) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, limiter.ErrInvalidLease) || errors.Is(err, limiter.ErrInvalidCost) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, limiter.ErrReservationNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return err
+}
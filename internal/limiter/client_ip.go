@@ -0,0 +1,111 @@
+package limiter
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+// ipResolver finds the client address of a request behind trusted proxies.
+type ipResolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+func newIPResolver(c config.ClientIPConfig) ipResolver {
+	trusted, _ := c.Prefixes()
+	return ipResolver{trusted: trusted, header: c.Header}
+}
+
+// resolve returns the client address of req. Without a peer address it
+// trusts req.IP as before. Otherwise the forwarding header is only read when
+// the peer is a trusted proxy, and it is walked from the nearest hop
+// outwards, stopping at the first address that is not a trusted proxy.
+func (r ipResolver) resolve(req CheckRequest) string {
+	if req.PeerAddr == "" {
+		return req.IP
+	}
+	peer, err := parseIP(req.PeerAddr)
+	if err != nil {
+		return req.PeerAddr
+	}
+	if !r.isTrusted(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	switch r.header {
+	case config.HeaderXRealIP:
+		if v := header(req, "X-Real-IP"); v != "" {
+			hops = []string{v}
+		}
+	case config.HeaderForwarded:
+		hops = forwardedFor(header(req, "Forwarded"))
+	default:
+		if v := header(req, "X-Forwarded-For"); v != "" {
+			hops = strings.Split(v, ",")
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseIP(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr
+		if !r.isTrusted(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func (r ipResolver) isTrusted(addr netip.Addr) bool {
+	for _, p := range r.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// header looks up a request header case-insensitively. Repeated headers are
+// expected joined with commas.
+func header(req CheckRequest, name string) string {
+	if v, ok := req.Headers[name]; ok {
+		return v
+	}
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// forwardedFor returns the for= addresses of an RFC 7239 Forwarded header,
+// one per hop. Hops without a usable address yield a value that does not
+// parse, which stops resolution there.
+func forwardedFor(v string) []string {
+	if v == "" {
+		return nil
+	}
+
+	var hops []string
+	for _, elem := range strings.Split(v, ",") {
+		addr := ""
+		for _, pair := range strings.Split(elem, ";") {
+			key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				addr = strings.Trim(val, `"`)
+				if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+					addr = addr[1 : len(addr)-1]
+				}
+			}
+		}
+		hops = append(hops, addr)
+	}
+	return hops
+}
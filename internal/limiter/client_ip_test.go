@@ -0,0 +1,149 @@
+package limiter
+
+import (
+	"testing"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "2001:db8:ffff::1"}
+
+	tests := []struct {
+		name    string
+		header  string
+		peer    string
+		ip      string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name: "no peer address keeps ip",
+			ip:   "1.2.3.4",
+			want: "1.2.3.4",
+		},
+		{
+			name:    "untrusted peer cannot spoof xff",
+			peer:    "203.0.113.9:443",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "trusted peer",
+			peer:    "10.0.0.1:5555",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			want:    "1.2.3.4",
+		},
+		{
+			name:    "spoofed leftmost xff hop is ignored",
+			peer:    "10.0.0.1",
+			headers: map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4"},
+			want:    "1.2.3.4",
+		},
+		{
+			name:    "chain of trusted proxies",
+			peer:    "10.0.0.1",
+			headers: map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.2"},
+			want:    "1.2.3.4",
+		},
+		{
+			name:    "all hops trusted",
+			peer:    "10.0.0.1",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			want:    "10.0.0.3",
+		},
+		{
+			name:    "garbage hop stops the walk",
+			peer:    "10.0.0.1",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4, garbage"},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "header name is case-insensitive",
+			peer:    "10.0.0.1",
+			headers: map[string]string{"x-forwarded-for": "1.2.3.4"},
+			want:    "1.2.3.4",
+		},
+		{
+			name:    "mapped ipv4 peer",
+			peer:    "[::ffff:10.0.0.1]:80",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			want:    "1.2.3.4",
+		},
+		{
+			name:    "trusted ipv6 peer",
+			peer:    "[2001:db8:ffff::1]:443",
+			headers: map[string]string{"X-Forwarded-For": "2001:db8::7"},
+			want:    "2001:db8::7",
+		},
+		{
+			name:    "x-real-ip",
+			header:  config.HeaderXRealIP,
+			peer:    "10.0.0.1",
+			headers: map[string]string{"X-Real-IP": "1.2.3.4", "X-Forwarded-For": "6.6.6.6"},
+			want:    "1.2.3.4",
+		},
+		{
+			name:    "forwarded",
+			header:  config.HeaderForwarded,
+			peer:    "10.0.0.1",
+			headers: map[string]string{"Forwarded": "for=192.0.2.60;proto=http;by=203.0.113.43"},
+			want:    "192.0.2.60",
+		},
+		{
+			name:    "forwarded bracketed ipv6 with port",
+			header:  config.HeaderForwarded,
+			peer:    "10.0.0.1",
+			headers: map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711"`},
+			want:    "2001:db8:cafe::17",
+		},
+		{
+			name:    "forwarded bracketed ipv6",
+			header:  config.HeaderForwarded,
+			peer:    "10.0.0.1",
+			headers: map[string]string{"Forwarded": `For="[2001:db8:cafe::17]"`},
+			want:    "2001:db8:cafe::17",
+		},
+		{
+			name:    "forwarded spoofed first element",
+			header:  config.HeaderForwarded,
+			peer:    "10.0.0.1",
+			headers: map[string]string{"Forwarded": "for=6.6.6.6, for=1.2.3.4;proto=https"},
+			want:    "1.2.3.4",
+		},
+		{
+			name:    "forwarded obfuscated hop stops the walk",
+			header:  config.HeaderForwarded,
+			peer:    "10.0.0.1",
+			headers: map[string]string{"Forwarded": "for=1.2.3.4, for=_hidden"},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "forwarded element without for",
+			header:  config.HeaderForwarded,
+			peer:    "10.0.0.1",
+			headers: map[string]string{"Forwarded": "for=1.2.3.4, proto=https"},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "forwarded ignored when xff is configured",
+			peer:    "10.0.0.1",
+			headers: map[string]string{"Forwarded": "for=1.2.3.4"},
+			want:    "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == "" {
+				header = config.HeaderXForwardedFor
+			}
+			r := newIPResolver(config.ClientIPConfig{TrustedProxies: trusted, Header: header})
+			got := r.resolve(CheckRequest{IP: tt.ip, PeerAddr: tt.peer, Headers: tt.headers})
+			if got != tt.want {
+				t.Errorf("resolve = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package limiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/larrasket/hlimiter/internal/config"
+	"github.com/larrasket/hlimiter/internal/storage"
+)
+
+type AcquireResponse struct {
+	Allowed   bool   `json:"allowed"`
+	Remaining int    `json:"remaining"`
+	LeaseID   string `json:"lease_id"`
+	ExpiresAt int64  `json:"expires_at"`
+	Reason    string `json:"reason"`
+	Degraded  bool   `json:"degraded"`
+}
+
+// Acquire takes one in-flight slot on a concurrency limited API. The lease
+// must be returned with Release; leases that are never released expire after
+// the API's window. Requests without a rule are allowed or denied by the
+// unmatched policy; the default rule does not apply to Acquire.
+func (rl *RateLimiter) Acquire(ctx context.Context, req CheckRequest) (AcquireResponse, error) {
+	slog.Debug("concurrency acquire", "service", req.Service, "api", req.API, "ip", req.IP)
+	req.IP = rl.resolveIP(req)
+
+	apis, err := rl.serviceRules(ctx, req.Service)
+	if errors.Is(err, storage.ErrServiceNotFound) {
+		return rl.acquireUnmatched(req, ReasonUnregisteredService), nil
+	}
+	if err == nil {
+		var resp AcquireResponse
+		if resp, err = rl.acquire(ctx, rl.store, req, apis); err == nil || errors.Is(err, ErrAlgorithmMismatch) {
+			return resp, err
+		}
+	}
+
+	// Leases taken from the local fallback expire on their own; releasing
+	// them through the store is a no-op.
+	switch policy, fallback, cached := rl.failover(req.Service, err); policy {
+	case config.FailLocal:
+		resp, err := rl.acquire(ctx, fallback, req, cached)
+		if err != nil {
+			return AcquireResponse{}, err
+		}
+		resp.Degraded = true
+		return resp, nil
+	case config.FailClosed:
+		return AcquireResponse{Reason: ReasonStoreUnavailable, Degraded: true}, nil
+	default:
+		return AcquireResponse{Allowed: true, Remaining: -1, Reason: ReasonStoreUnavailable, Degraded: true}, nil
+	}
+}
+
+func (rl *RateLimiter) acquire(ctx context.Context, store storage.Backend, req CheckRequest, apis []config.API) (AcquireResponse, error) {
+	for _, api := range matchRules(apis, req.API, req.Method) {
+		if api.Algorithm != "concurrency" {
+			return AcquireResponse{}, fmt.Errorf("%w: api %s uses %s", ErrAlgorithmMismatch, api.Path, api.Algorithm)
+		}
+
+		key := rl.buildKey(req, api)
+		leaseID, err := newLeaseID(key)
+		if err != nil {
+			return AcquireResponse{}, err
+		}
+
+		allowed, remaining, expiresAt, err := store.AcquireLease(ctx, key, leaseID, api.Limit, api.WindowMs())
+		if err != nil {
+			slog.Error("concurrency acquire failed", "error", err, "key", key)
+			return AcquireResponse{}, err
+		}
+		slog.Info("concurrency acquire", "allowed", allowed, "remaining", remaining)
+
+		if !allowed {
+			return AcquireResponse{Allowed: false, Remaining: remaining, Reason: ReasonRule}, nil
+		}
+		return AcquireResponse{Allowed: true, Remaining: remaining, LeaseID: leaseID, ExpiresAt: expiresAt, Reason: ReasonRule}, nil
+	}
+
+	return rl.acquireUnmatched(req, ReasonUnmatchedAPI), nil
+}
+
+func (rl *RateLimiter) acquireUnmatched(req CheckRequest, reason string) AcquireResponse {
+	rl.mu.RLock()
+	policy := rl.unmatched.Policy
+	rl.mu.RUnlock()
+
+	slog.Warn("no rule for request", "service", req.Service, "api", req.API, "reason", reason, "policy", policy)
+	if policy == config.UnmatchedDeny {
+		return AcquireResponse{Reason: reason}
+	}
+	return AcquireResponse{Allowed: true, Remaining: -1, Reason: reason}
+}
+
+// Release frees the slot held by a lease. It reports false when the lease
+// had already expired or been released.
+func (rl *RateLimiter) Release(ctx context.Context, leaseID string) (bool, error) {
+	if leaseID == "" {
+		return false, nil
+	}
+
+	key, err := leaseKey(leaseID)
+	if err != nil {
+		return false, err
+	}
+
+	released, err := rl.store.ReleaseLease(ctx, key, leaseID)
+	if err != nil {
+		slog.Error("concurrency release failed", "error", err, "key", key)
+		return false, err
+	}
+	slog.Debug("concurrency release", "key", key, "released", released)
+	return released, nil
+}
+
+// Lease IDs carry the limiter key they were issued for, so Release does not
+// need the original request.
+func newLeaseID(key string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(key)) + "." + hex.EncodeToString(b), nil
+}
+
+func leaseKey(leaseID string) (string, error) {
+	enc, _, ok := strings.Cut(leaseID, ".")
+	if !ok {
+		return "", ErrInvalidLease
+	}
+	key, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil || len(key) == 0 {
+		return "", ErrInvalidLease
+	}
+	return string(key), nil
+}
@@ -0,0 +1,260 @@
+package limiter
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+var jwkCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// JWTVerifier checks bearer tokens for the jwt:<claim> key strategy. It is
+// built by NewJWTVerifier, so a bad JWKS file can be rejected before any of
+// a reloaded config is applied, and installed with SetJWT.
+type JWTVerifier struct {
+	secrets  [][]byte
+	keys     []jwk
+	fallback config.KeyStrategy
+}
+
+type jwk struct {
+	kid string
+	key crypto.PublicKey
+}
+
+func NewJWTVerifier(c config.JWTConfig) (JWTVerifier, error) {
+	v := JWTVerifier{fallback: c.Fallback}
+	if v.fallback == "" {
+		v.fallback = "ip"
+	}
+	for _, s := range c.HMACSecrets {
+		v.secrets = append(v.secrets, []byte(s))
+	}
+	if c.JWKSFile != "" {
+		data, err := os.ReadFile(c.JWKSFile)
+		if err != nil {
+			return JWTVerifier{}, fmt.Errorf("read jwks: %w", err)
+		}
+		if v.keys, err = parseJWKS(data); err != nil {
+			return JWTVerifier{}, fmt.Errorf("parse jwks %s: %w", c.JWKSFile, err)
+		}
+	}
+	return v, nil
+}
+
+// parseJWKS reads the RSA and EC keys of a JSON Web Key Set. Keys of other
+// types are skipped.
+func parseJWKS(data []byte) ([]jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []jwk
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, errN := decodeBigInt(k.N)
+			e, errE := decodeBigInt(k.E)
+			if errN != nil || errE != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("invalid RSA key %q", k.Kid)
+			}
+			keys = append(keys, jwk{kid: k.Kid, key: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case "EC":
+			curve, ok := jwkCurves[k.Crv]
+			x, errX := decodeBigInt(k.X)
+			y, errY := decodeBigInt(k.Y)
+			if !ok || errX != nil || errY != nil {
+				return nil, fmt.Errorf("invalid EC key %q", k.Kid)
+			}
+			keys = append(keys, jwk{kid: k.Kid, key: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}})
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// claim returns a claim of the request's bearer token as a string. It
+// reports false if there is no token, the token does not verify or has
+// expired, or the claim is missing or not a scalar.
+func (v JWTVerifier) claim(req CheckRequest, name string, now time.Time) (string, bool) {
+	scheme, token, ok := strings.Cut(header(req, "Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	claims, err := v.verify(strings.TrimSpace(token), now)
+	if err != nil {
+		return "", false
+	}
+
+	switch val := claims[name].(type) {
+	case string:
+		return val, val != ""
+	case json.Number:
+		return val.String(), true
+	case bool:
+		return fmt.Sprint(val), true
+	default:
+		return "", false
+	}
+}
+
+func (v JWTVerifier) verify(token string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var head struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if !v.checkSignature(head.Alg, head.Kid, parts[0]+"."+parts[1], sig) {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	exp, hasExp, err := timeClaim(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if hasExp && float64(now.Unix()) >= exp {
+		return nil, errors.New("token expired")
+	}
+	nbf, hasNbf, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return nil, err
+	}
+	if hasNbf && float64(now.Unix()) < nbf {
+		return nil, errors.New("token not yet valid")
+	}
+	return claims, nil
+}
+
+// timeClaim reads a NumericDate claim. A claim that is present but not a
+// number is an error, so a malformed exp cannot make a token never expire.
+func timeClaim(claims map[string]any, name string) (float64, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return 0, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false, fmt.Errorf("claim %s is not a number", name)
+	}
+	t, err := n.Float64()
+	if err != nil {
+		return 0, false, fmt.Errorf("claim %s is not a number", name)
+	}
+	return t, true, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// checkSignature verifies sig over signed with alg. HS algorithms are tried
+// against every secret, RS and ES algorithms against the JWKS keys of the
+// matching type, restricted to kid when the token names one.
+func (v JWTVerifier) checkSignature(alg, kid, signed string, sig []byte) bool {
+	if len(alg) != 5 {
+		return false
+	}
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return false
+	}
+
+	if alg[:2] == "HS" {
+		for _, secret := range v.secrets {
+			mac := hmac.New(hash.New, secret)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), sig) {
+				return true
+			}
+		}
+		return false
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	for _, k := range v.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		switch key := k.key.(type) {
+		case *rsa.PublicKey:
+			if alg[:2] == "RS" && rsa.VerifyPKCS1v15(key, hash, digest, sig) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if alg[:2] == "ES" && len(sig) == 2*size {
+				r := new(big.Int).SetBytes(sig[:size])
+				s := new(big.Int).SetBytes(sig[size:])
+				if ecdsa.Verify(key, digest, r, s) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+package limiter
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func jsonSegment(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b64(b)
+}
+
+// signedToken builds a token with the given header and claims, signed by
+// sign over the encoded header and claims.
+func signedToken(t *testing.T, header, claims map[string]any, sign func(signed []byte) []byte) string {
+	t.Helper()
+	signed := jsonSegment(t, header) + "." + jsonSegment(t, claims)
+	return signed + "." + b64(sign([]byte(signed)))
+}
+
+func hmacSigner(hash crypto.Hash, secret string) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(hash.New, []byte(secret))
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func digest(hash crypto.Hash, signed []byte) []byte {
+	h := hash.New()
+	h.Write(signed)
+	return h.Sum(nil)
+}
+
+func TestJWTClaim(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": %q, "y": %q},
+		{"kty": "RSA", "kid": "rsa1", "n": %q, "e": "AQAB"},
+		{"kty": "OKP", "kid": "ignored"}
+	]}`, b64(ecKey.X.FillBytes(make([]byte, 32))), b64(ecKey.Y.FillBytes(make([]byte, 32))), b64(rsaKey.N.Bytes()))
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, []byte(jwks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewJWTVerifier(config.JWTConfig{HMACSecrets: []string{"old", "s3cret"}, JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	valid := map[string]any{"sub": "alice", "exp": now.Unix() + 60}
+	hs256 := hmacSigner(crypto.SHA256, "s3cret")
+	es256 := func(signed []byte) []byte {
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest(crypto.SHA256, signed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	rs256 := func(signed []byte) []byte {
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest(crypto.SHA256, signed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	noSignature := func([]byte) []byte { return nil }
+
+	tests := []struct {
+		name   string
+		auth   string
+		claim  string
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "HS256",
+			auth:   "Bearer " + signedToken(t, map[string]any{"alg": "HS256"}, valid, hs256),
+			want:   "alice",
+			wantOK: true,
+		},
+		{
+			name:   "HS512 with an older secret",
+			auth:   "Bearer " + signedToken(t, map[string]any{"alg": "HS512"}, valid, hmacSigner(crypto.SHA512, "old")),
+			want:   "alice",
+			wantOK: true,
+		},
+		{
+			name:   "lowercase scheme",
+			auth:   "bearer " + signedToken(t, map[string]any{"alg": "HS256"}, valid, hs256),
+			want:   "alice",
+			wantOK: true,
+		},
+		{
+			name: "wrong secret",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "HS256"}, valid, hmacSigner(crypto.SHA256, "guess")),
+		},
+		{
+			name: "alg none",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "none"}, valid, noSignature),
+		},
+		{
+			name: "unknown alg",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "HS1"}, valid, hs256),
+		},
+		{
+			name: "hmac signature under an RSA alg",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "RS256"}, valid, hs256),
+		},
+		{
+			name: "not a bearer token",
+			auth: "Basic " + signedToken(t, map[string]any{"alg": "HS256"}, valid, hs256),
+		},
+		{
+			name: "malformed token",
+			auth: "Bearer abc.def",
+		},
+		{
+			name:   "ES256 with kid",
+			auth:   "Bearer " + signedToken(t, map[string]any{"alg": "ES256", "kid": "ec1"}, valid, es256),
+			want:   "alice",
+			wantOK: true,
+		},
+		{
+			name:   "ES256 without kid",
+			auth:   "Bearer " + signedToken(t, map[string]any{"alg": "ES256"}, valid, es256),
+			want:   "alice",
+			wantOK: true,
+		},
+		{
+			name: "wrong kid",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "ES256", "kid": "rsa1"}, valid, es256),
+		},
+		{
+			name: "unknown kid",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "ES256", "kid": "other"}, valid, es256),
+		},
+		{
+			name:   "RS256",
+			auth:   "Bearer " + signedToken(t, map[string]any{"alg": "RS256", "kid": "rsa1"}, valid, rs256),
+			want:   "alice",
+			wantOK: true,
+		},
+		{
+			name: "RSA signature under an EC alg",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "ES256"}, valid, rs256),
+		},
+		{
+			name: "expired",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "exp": now.Unix()}, hs256),
+		},
+		{
+			name: "not yet valid",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "nbf": now.Unix() + 1}, hs256),
+		},
+		{
+			name: "exp is not a number",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "exp": "1"}, hs256),
+		},
+		{
+			name: "nbf is not a number",
+			auth: "Bearer " + signedToken(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "nbf": nil}, hs256),
+		},
+		{
+			name:   "numeric claim",
+			auth:   "Bearer " + signedToken(t, map[string]any{"alg": "HS256"}, map[string]any{"tenant_id": 42}, hs256),
+			claim:  "tenant_id",
+			want:   "42",
+			wantOK: true,
+		},
+		{
+			name:  "missing claim",
+			auth:  "Bearer " + signedToken(t, map[string]any{"alg": "HS256"}, valid, hs256),
+			claim: "tenant_id",
+		},
+		{
+			name:  "object claim",
+			auth:  "Bearer " + signedToken(t, map[string]any{"alg": "HS256"}, map[string]any{"tenant_id": map[string]any{}}, hs256),
+			claim: "tenant_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claim := tt.claim
+			if claim == "" {
+				claim = "sub"
+			}
+			req := CheckRequest{Headers: map[string]string{"authorization": tt.auth}}
+			got, ok := v.claim(req, claim, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("claim = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewJWTVerifierErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"bad-json": `{"keys": [`,
+		"bad-rsa":  `{"keys": [{"kty": "RSA", "kid": "r", "n": "", "e": "AQAB"}]}`,
+		"bad-ec":   `{"keys": [{"kty": "EC", "kid": "e", "crv": "P-192", "x": "AQ", "y": "AQ"}]}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"missing", "bad-json", "bad-rsa", "bad-ec"} {
+		if _, err := NewJWTVerifier(config.JWTConfig{JWKSFile: filepath.Join(dir, name)}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestJWTKeyFallback(t *testing.T) {
+	v, err := NewJWTVerifier(config.JWTConfig{HMACSecrets: []string{"s3cret"}, Fallback: "ip/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := New(nil)
+	rl.SetJWT(v)
+
+	api := config.API{Path: "/a", KeyStrategy: "jwt:sub"}
+	token := signedToken(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice"}, hmacSigner(crypto.SHA256, "s3cret"))
+
+	tests := []struct {
+		auth string
+		want string
+	}{
+		{"Bearer " + token, "{s:/a}:s:/a:jwt:sub:alice"},
+		{"Bearer " + token + "x", "{s:/a}:s:/a:ip:1.2.3.0/24"},
+		{"", "{s:/a}:s:/a:ip:1.2.3.0/24"},
+	}
+	for _, tt := range tests {
+		req := CheckRequest{Service: "s", IP: "1.2.3.4", Headers: map[string]string{"Authorization": tt.auth}}
+		if got := rl.buildKey(req, api); got != tt.want {
+			t.Errorf("buildKey(%q) = %q, want %q", tt.auth, got, tt.want)
+		}
+	}
+}
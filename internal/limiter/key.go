@@ -0,0 +1,114 @@
+package limiter
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+func sanitizeKeyPart(s string) string {
+	s = strings.ReplaceAll(s, ":", "_")
+	s = strings.ReplaceAll(s, " ", "_")
+	if len(s) > 256 {
+		s = s[:256]
+	}
+	return s
+}
+
+// slotTag is a Redis Cluster hash tag of the service and path template. All
+// rules of a path are evaluated in one script, so their keys must share a
+// slot; braces of template parameters are dropped as they would end the tag.
+func slotTag(service, path string) string {
+	return "{" + strings.NewReplacer("{", "", "}", "").Replace(sanitizeKeyPart(service)+":"+path) + "}"
+}
+
+// buildKey names the counter a request is checked against: the slot tag,
+// service, path, then one segment per part of the rule's key strategy, in
+// order.
+func (rl *RateLimiter) buildKey(req CheckRequest, api config.API) string {
+	path := sanitizeKeyPart(api.Path)
+	if api.EffectiveKeyPath() == config.KeyPathConcrete {
+		path = sanitizeKeyPart(req.API)
+	}
+	if len(api.Methods) > 0 {
+		path += "@" + sanitizeKeyPart(strings.ToUpper(strings.Join(api.Methods, ",")))
+	}
+
+	segments := []string{slotTag(req.Service, api.Path), sanitizeKeyPart(req.Service), path}
+	for _, part := range api.KeyStrategy.Parts() {
+		if claim, ok := strings.CutPrefix(part, "jwt:"); ok {
+			segments = append(segments, rl.jwtPart(req, claim)...)
+			continue
+		}
+		segments = append(segments, keyPart(req, part))
+	}
+	return strings.Join(segments, ":")
+}
+
+// jwtPart keys on a claim of the request's verified bearer token, or on the
+// jwt fallback strategy when there is no such token or claim.
+func (rl *RateLimiter) jwtPart(req CheckRequest, claim string) []string {
+	rl.mu.RLock()
+	v := rl.jwt
+	rl.mu.RUnlock()
+
+	if value, ok := v.claim(req, claim, time.Now()); ok {
+		return []string{"jwt:" + claim + ":" + sanitizeKeyPart(value)}
+	}
+	var segments []string
+	for _, part := range v.fallback.Parts() {
+		segments = append(segments, keyPart(req, part))
+	}
+	return segments
+}
+
+func keyPart(req CheckRequest, part string) string {
+	kind, arg, _ := strings.Cut(part, ":")
+	kind, masks, _ := strings.Cut(kind, "/")
+	switch kind {
+	case "ip":
+		return "ip:" + ipKey(req.IP, masks)
+	case "header":
+		return "header:" + arg + ":" + sanitizeKeyPart(req.Headers[arg])
+	case "query":
+		return "query:" + arg + ":" + sanitizeKeyPart(req.Query[arg])
+	case "attr":
+		return "attr:" + arg + ":" + sanitizeKeyPart(req.Attributes[arg])
+	default:
+		return "default"
+	}
+}
+
+// ipKey normalises a client address: it drops a port, unmaps IPv4 addresses
+// embedded in IPv6 and, given masks (see config.IPMasks), reduces the
+// address to its network. Addresses that do not parse are used as they are.
+func ipKey(raw, masks string) string {
+	addr, err := parseIP(raw)
+	if err != nil {
+		return sanitizeKeyPart(raw)
+	}
+
+	if masks != "" {
+		v4, v6, err := config.IPMasks(masks)
+		bits := v4
+		if addr.Is6() {
+			bits = v6
+		}
+		if err == nil {
+			if prefix, err := addr.Prefix(bits); err == nil {
+				return sanitizeKeyPart(prefix.String())
+			}
+		}
+	}
+	return sanitizeKeyPart(addr.String())
+}
+
+func parseIP(raw string) (netip.Addr, error) {
+	if ap, err := netip.ParseAddrPort(raw); err == nil {
+		return ap.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(raw)
+	return addr.Unmap(), err
+}
@@ -0,0 +1,65 @@
+package limiter
+
+import (
+	"testing"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+func TestIPKey(t *testing.T) {
+	tests := []struct {
+		ip    string
+		masks string
+		want  string
+	}{
+		{"1.2.3.4", "", "1.2.3.4"},
+		{"1.2.3.4:5678", "", "1.2.3.4"},
+		{"[2001:db8::1]:443", "", "2001_db8__1"},
+		{"::ffff:1.2.3.4", "", "1.2.3.4"},
+		{"1.2.3.4:80", "24", "1.2.3.0/24"},
+		{"2001:db8:1:2:3:4:5:6", "24", "2001_db8_1_2__/64"},
+		{"2001:db8:1:2:3:4:5:6", "64", "2001_db8_1_2__/64"},
+		{"1.2.3.4", "64", "1.2.3.4/32"},
+		{"2001:db8:1:2:3:4:5:6", "24/48", "2001_db8_1__/48"},
+		{"2001:db8:1:2:3:4:5:6", "24/128", "2001_db8_1_2_3_4_5_6/128"},
+		{"garbage:x", "24", "garbage_x"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := ipKey(tt.ip, tt.masks); got != tt.want {
+			t.Errorf("ipKey(%q, %q) = %q, want %q", tt.ip, tt.masks, got, tt.want)
+		}
+	}
+}
+
+func TestCheckIPMasks(t *testing.T) {
+	for _, k := range []config.KeyStrategy{"ip/24", "ip/64", "ip/128", "ip/24/64", "ip/32/128+header:X"} {
+		if err := config.CheckKeyStrategy(k); err != nil {
+			t.Errorf("%s: %v", k, err)
+		}
+	}
+	for _, k := range []config.KeyStrategy{"ip/", "ip/0", "ip/129", "ip/33/64", "ip/24/0", "ip/24/", "ip/a", "header/24:X"} {
+		if config.CheckKeyStrategy(k) == nil {
+			t.Errorf("%s: accepted", k)
+		}
+	}
+}
+
+func TestBuildKeySlotTag(t *testing.T) {
+	rl := New(nil)
+	req := CheckRequest{Service: "s", API: "/users/42", IP: "1.2.3.4"}
+
+	tests := []struct {
+		api  config.API
+		want string
+	}{
+		{config.API{Path: "/users/{id}", KeyStrategy: "ip"}, "{s:/users/id}:s:/users/{id}:ip:1.2.3.4"},
+		{config.API{Path: "/users/{id}", KeyPath: config.KeyPathConcrete, KeyStrategy: "ip"}, "{s:/users/id}:s:/users/42:ip:1.2.3.4"},
+		{config.API{Path: "/users/*", Methods: []string{"get"}, KeyStrategy: "default"}, "{s:/users/*}:s:/users/*@GET:default"},
+	}
+	for _, tt := range tests {
+		if got := rl.buildKey(req, tt.api); got != tt.want {
+			t.Errorf("buildKey(%+v) = %q, want %q", tt.api, got, tt.want)
+		}
+	}
+}
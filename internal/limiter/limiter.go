@@ -0,0 +1,437 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"github.com/larrasket/hlimiter/internal/config"
+	"github.com/larrasket/hlimiter/internal/storage"
+)
+
+var (
+	ErrAlgorithmMismatch = errors.New("algorithm mismatch")
+	ErrInvalidLease      = errors.New("invalid lease id")
+	ErrInvalidCost       = errors.New("cost must not be negative")
+
+	ErrReservationNotFound = storage.ErrReservationNotFound
+)
+
+// Limiter decides whether a request is within the limits registered for its
+// service. Implementations may wrap one another, e.g. to add metrics.
+type Limiter interface {
+	Register(ctx context.Context, serviceName string, apis []config.API) error
+	Check(ctx context.Context, req CheckRequest) (CheckResponse, error)
+	Peek(ctx context.Context, req CheckRequest) (CheckResponse, error)
+	Refund(ctx context.Context, reservationID string, units int) (int, error)
+	Acquire(ctx context.Context, req CheckRequest) (AcquireResponse, error)
+	Release(ctx context.Context, leaseID string) (bool, error)
+}
+
+type CheckRequest struct {
+	Service string            `json:"service"`
+	API     string            `json:"api"`
+	Method  string            `json:"method"`
+	IP      string            `json:"ip"`
+	Headers map[string]string `json:"headers"`
+	Cost    int               `json:"cost"`
+
+	// PeerAddr is the address of the immediate peer of the caller. When set,
+	// the client address is resolved from it and the forwarding headers
+	// instead of IP.
+	PeerAddr string `json:"peer_addr"`
+
+	// Query holds the query parameters of an HTTP request and Attributes
+	// any other caller metadata, such as gRPC metadata or a queue consumer
+	// name, for the query:<name> and attr:<name> key strategies.
+	Query      map[string]string `json:"query"`
+	Attributes map[string]string `json:"attributes"`
+
+	// Refundable asks for a reservation ID, so the request can be refunded
+	// later. Without it nothing is kept beyond the algorithms' own state.
+	Refundable bool `json:"refundable"`
+}
+
+// Reasons reported with each decision, so callers can tell a decision made
+// by a rule from one made for lack of a rule.
+const (
+	ReasonRule                = "rule"
+	ReasonDefaultRule         = "default_rule"
+	ReasonUnregisteredService = "unregistered_service"
+	ReasonUnmatchedAPI        = "unmatched_api"
+	ReasonStoreUnavailable    = "store_unavailable"
+)
+
+// CheckResponse describes the decision and the rule that produced it. When
+// several rules apply, the metadata is that of the most restrictive one.
+// RetryAfterMs is set on denied requests and is -1 if the requested cost
+// exceeds what the rule can ever allow. Degraded marks decisions made by the
+// failure policy because the store was unavailable.
+type CheckResponse struct {
+	Allowed      bool  `json:"allowed"`
+	Remaining    int   `json:"remaining"`
+	ResetAt      int64 `json:"reset_at"`
+	DelayMs      int64 `json:"delay_ms"`
+	RetryAfterMs int64 `json:"retry_after_ms"`
+
+	ReservationID string `json:"reservation_id"`
+
+	Limit       int    `json:"limit"`
+	Path        string `json:"path"`
+	Algorithm   string `json:"algorithm"`
+	KeyStrategy string `json:"key_strategy"`
+
+	Reason   string `json:"reason"`
+	Degraded bool   `json:"degraded"`
+}
+
+type RateLimiter struct {
+	store storage.Backend
+
+	// mu guards the policies, the local fallback store and the last known
+	// rules of each service, which the fallback enforces. The Set* methods
+	// are called again whenever the config is reloaded.
+	mu        sync.RWMutex
+	failure   config.FailureConfig
+	unmatched config.UnmatchedConfig
+	clientIP  ipResolver
+	jwt       JWTVerifier
+	refund    config.RefundConfig
+	fallback  storage.Backend
+	rules     map[string][]config.API
+}
+
+var _ Limiter = (*RateLimiter)(nil)
+
+func New(store storage.Backend) *RateLimiter {
+	slog.Info("rate limiter initialized")
+	return &RateLimiter{
+		store:     store,
+		failure:   config.FailureConfig{Policy: config.FailOpen},
+		unmatched: config.UnmatchedConfig{Policy: config.UnmatchedAllow},
+		jwt:       JWTVerifier{fallback: "ip"},
+		refund:    config.RefundConfig{ReservationTTL: config.DefaultReservationTTL},
+		rules:     make(map[string][]config.API),
+	}
+}
+
+// SetFailurePolicy sets how requests are answered while the store is down.
+func (rl *RateLimiter) SetFailurePolicy(f config.FailureConfig) {
+	local := f.Policy == config.FailLocal
+	for _, p := range f.Services {
+		local = local || p == config.FailLocal
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.failure = f
+	if local && rl.fallback == nil {
+		rl.fallback = storage.NewMemory(0)
+	}
+}
+
+// SetUnmatchedPolicy sets how requests without a rule are answered.
+func (rl *RateLimiter) SetUnmatchedPolicy(u config.UnmatchedConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.unmatched = u
+}
+
+// SetRefund sets how long reservations of refundable requests are kept.
+func (rl *RateLimiter) SetRefund(r config.RefundConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.refund = r
+}
+
+// SetClientIP sets how client addresses are resolved behind proxies.
+func (rl *RateLimiter) SetClientIP(c config.ClientIPConfig) {
+	r := newIPResolver(c)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.clientIP = r
+}
+
+// SetJWT sets how bearer tokens are verified for jwt:<claim> keys.
+func (rl *RateLimiter) SetJWT(v JWTVerifier) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.jwt = v
+}
+
+func (rl *RateLimiter) resolveIP(req CheckRequest) string {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.clientIP.resolve(req)
+}
+
+// serviceRules loads the rules of a service and, if the service fails over
+// to the local policy, remembers them for the fallback. The write lock is
+// only taken when the remembered rules change.
+func (rl *RateLimiter) serviceRules(ctx context.Context, service string) ([]config.API, error) {
+	apis, err := rl.store.GetServiceConfig(ctx, service)
+	if err != nil && !errors.Is(err, storage.ErrServiceNotFound) {
+		return apis, err
+	}
+
+	rl.mu.RLock()
+	keep := err == nil && rl.failure.PolicyFor(service) == config.FailLocal
+	cached, known := rl.rules[service]
+	rl.mu.RUnlock()
+
+	switch {
+	case keep && (!known || !reflect.DeepEqual(cached, apis)):
+		rl.mu.Lock()
+		rl.rules[service] = apis
+		rl.mu.Unlock()
+	case !keep && known:
+		rl.mu.Lock()
+		delete(rl.rules, service)
+		rl.mu.Unlock()
+	}
+	return apis, err
+}
+
+// failover picks how to answer a request the store could not. For the local
+// policy it also returns the fallback store and the service's last known
+// rules; without those it fails open.
+func (rl *RateLimiter) failover(service string, cause error) (string, storage.Backend, []config.API) {
+	rl.mu.RLock()
+	policy := rl.failure.PolicyFor(service)
+	fallback := rl.fallback
+	apis, known := rl.rules[service]
+	rl.mu.RUnlock()
+
+	slog.Warn("store unavailable, applying failure policy", "error", cause, "service", service, "policy", policy)
+	if policy == config.FailLocal && !known {
+		slog.Warn("no rules known for local fallback, allowing request", "service", service)
+		return config.FailOpen, nil, nil
+	}
+	return policy, fallback, apis
+}
+
+func (rl *RateLimiter) Register(ctx context.Context, serviceName string, apis []config.API) error {
+	if err := rl.store.RegisterService(ctx, serviceName, apis); err != nil {
+		return err
+	}
+	slog.Info("service registered", "service", serviceName, "apis", len(apis))
+	return nil
+}
+
+func (rl *RateLimiter) Check(ctx context.Context, req CheckRequest) (CheckResponse, error) {
+	return rl.check(ctx, req, true)
+}
+
+// Peek reports whether a request would be allowed without consuming quota.
+// Remaining is the number of units available right now.
+func (rl *RateLimiter) Peek(ctx context.Context, req CheckRequest) (CheckResponse, error) {
+	return rl.check(ctx, req, false)
+}
+
+func (rl *RateLimiter) check(ctx context.Context, req CheckRequest, consume bool) (CheckResponse, error) {
+	slog.Debug("rate limit check", "service", req.Service, "api", req.API, "method", req.Method, "ip", req.IP, "cost", req.Cost, "consume", consume)
+
+	if req.Cost < 0 {
+		return CheckResponse{}, fmt.Errorf("%w: %d", ErrInvalidCost, req.Cost)
+	}
+	cost := req.Cost
+	if cost == 0 {
+		cost = 1
+	}
+	req.IP = rl.resolveIP(req)
+
+	apis, err := rl.serviceRules(ctx, req.Service)
+	registered := !errors.Is(err, storage.ErrServiceNotFound)
+	if !registered {
+		err = nil
+	}
+	if err == nil {
+		var resp CheckResponse
+		if resp, err = rl.evaluate(ctx, rl.store, req, apis, registered, cost, consume); err == nil || errors.Is(err, ErrAlgorithmMismatch) {
+			return resp, err
+		}
+	}
+
+	switch policy, fallback, cached := rl.failover(req.Service, err); policy {
+	case config.FailLocal:
+		// Local reservations cannot be refunded through the store.
+		req.Refundable = false
+		resp, err := rl.evaluate(ctx, fallback, req, cached, true, cost, consume)
+		if err != nil {
+			return CheckResponse{}, err
+		}
+		resp.Degraded = true
+		return resp, nil
+	case config.FailClosed:
+		return CheckResponse{Reason: ReasonStoreUnavailable, Degraded: true}, nil
+	default:
+		return CheckResponse{Allowed: true, Remaining: -1, Reason: ReasonStoreUnavailable, Degraded: true}, nil
+	}
+}
+
+// evaluate decides req against the rules of its path, or by the unmatched
+// policy when the path has none.
+func (rl *RateLimiter) evaluate(ctx context.Context, store storage.Backend, req CheckRequest, apis []config.API, registered bool, cost int, consume bool) (CheckResponse, error) {
+	if rules := matchRules(apis, req.API, req.Method); len(rules) > 0 {
+		return rl.decide(ctx, store, req, rules, cost, consume)
+	}
+
+	reason := ReasonUnmatchedAPI
+	if !registered {
+		reason = ReasonUnregisteredService
+	}
+
+	rl.mu.RLock()
+	u := rl.unmatched
+	rl.mu.RUnlock()
+
+	slog.Warn("no rule for request", "service", req.Service, "api", req.API, "reason", reason, "policy", u.Policy)
+	switch u.Policy {
+	case config.UnmatchedDeny:
+		return CheckResponse{Reason: reason}, nil
+	case config.UnmatchedDefault:
+		rule := *u.Rule
+		rule.Path = req.API
+		resp, err := rl.decide(ctx, store, req, []config.API{rule}, cost, consume)
+		resp.Reason = ReasonDefaultRule
+		return resp, err
+	default:
+		return CheckResponse{Allowed: true, Remaining: -1, Reason: reason}, nil
+	}
+}
+
+// decide evaluates rules, all on req's path, against store.
+func (rl *RateLimiter) decide(ctx context.Context, store storage.Backend, req CheckRequest, rules []config.API, cost int, consume bool) (CheckResponse, error) {
+	limits := make([]storage.Limit, 0, len(rules))
+	for _, api := range rules {
+		if api.Algorithm == "concurrency" {
+			return CheckResponse{}, fmt.Errorf("%w: api %s is concurrency limited, use Acquire", ErrAlgorithmMismatch, api.Path)
+		}
+
+		key := rl.buildKey(req, api) + ":" + ruleSuffix(api)
+		slog.Debug("checking rate limit", "algorithm", api.Algorithm, "key", key)
+
+		burst := api.Burst
+		if burst == 0 {
+			burst = api.Limit
+		}
+		limits = append(limits, storage.Limit{
+			Key:       key,
+			Algorithm: api.Algorithm,
+			Limit:     api.Limit,
+			Burst:     burst,
+			WindowMs:  api.WindowMs(),
+			Cost:      cost,
+		})
+	}
+
+	var results []storage.Result
+	var reservationID string
+	var err error
+	if consume {
+		var reserveMs int64
+		if req.Refundable {
+			rl.mu.RLock()
+			reserveMs = rl.refund.ReservationTTL.Milliseconds()
+			rl.mu.RUnlock()
+		}
+		results, reservationID, err = store.Evaluate(ctx, limits, reserveMs)
+	} else {
+		results, err = store.Peek(ctx, limits)
+	}
+	if err != nil {
+		slog.Error("rate limit check failed", "error", err, "service", req.Service, "api", req.API)
+		return CheckResponse{}, err
+	}
+
+	if !consume {
+		for i := range results {
+			if results[i].Allowed {
+				results[i].Remaining += cost
+			}
+		}
+	}
+
+	i := mostRestrictive(results)
+	allowed := results[i].Allowed
+	slog.Info("rate limit check", "algorithm", rules[i].Algorithm, "allowed", allowed, "remaining", results[i].Remaining, "rules", len(rules), "consume", consume)
+
+	resp := CheckResponse{
+		Allowed:       allowed,
+		Remaining:     results[i].Remaining,
+		ResetAt:       results[i].ResetAt,
+		ReservationID: reservationID,
+		Limit:         rules[i].Limit,
+		Path:          rules[i].Path,
+		Algorithm:     rules[i].Algorithm,
+		KeyStrategy:   string(rules[i].KeyStrategy),
+		Reason:        ReasonRule,
+	}
+	if allowed {
+		for _, r := range results {
+			resp.DelayMs = max(resp.DelayMs, r.DelayMs)
+		}
+	} else {
+		resp.RetryAfterMs = results[i].RetryAfterMs
+	}
+	return resp, nil
+}
+
+// Refund gives units of an allowed request back, e.g. when the request
+// failed downstream. units of 0 refunds everything not yet refunded.
+func (rl *RateLimiter) Refund(ctx context.Context, reservationID string, units int) (int, error) {
+	if units < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidCost, units)
+	}
+
+	refunded, err := rl.store.Refund(ctx, reservationID, units)
+	if err != nil {
+		if !errors.Is(err, ErrReservationNotFound) {
+			slog.Error("refund failed", "error", err, "reservation", reservationID)
+		}
+		return 0, err
+	}
+	slog.Info("refund", "reservation", reservationID, "refunded", refunded)
+	return refunded, nil
+}
+
+// ruleSuffix keeps the keys of several rules on the same path apart. It
+// only depends on what identifies the rule's counter (see
+// config.CheckRules), so adding rules or tuning a limit keeps the counts.
+func ruleSuffix(api config.API) string {
+	return fmt.Sprintf("%s:%d:%s", api.Algorithm, api.WindowMs(), api.EffectiveKeyPath())
+}
+
+// mostRestrictive picks the result reported to the caller: the denying rule
+// with the longest wait, or when all allow, the one with the fewest remaining.
+func mostRestrictive(results []storage.Result) int {
+	best := 0
+	for i := 1; i < len(results); i++ {
+		r, b := results[i], results[best]
+		if r.Allowed != b.Allowed {
+			if !r.Allowed {
+				best = i
+			}
+			continue
+		}
+		if !r.Allowed && longerWait(r.RetryAfterMs, b.RetryAfterMs) {
+			best = i
+		}
+		if r.Allowed && (r.Remaining < b.Remaining || r.Remaining == b.Remaining && r.ResetAt > b.ResetAt) {
+			best = i
+		}
+	}
+	return best
+}
+
+// longerWait compares retry-after values where -1 means forever.
+func longerWait(a, b int64) bool {
+	if b < 0 {
+		return false
+	}
+	return a < 0 || a > b
+}
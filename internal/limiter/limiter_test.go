@@ -0,0 +1,106 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/larrasket/hlimiter/internal/config"
+	"github.com/larrasket/hlimiter/internal/storage"
+)
+
+func TestAddingRuleKeepsCounters(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory(100)
+	defer store.Close()
+	rl := New(store)
+
+	perMinute := config.API{Path: "/a", Algorithm: "fixed_window", KeyStrategy: "ip", Limit: 2, WindowSeconds: 60}
+	perHour := config.API{Path: "/a", Algorithm: "fixed_window", KeyStrategy: "ip", Limit: 100, WindowSeconds: 3600}
+	req := CheckRequest{Service: "s", API: "/a", IP: "1.2.3.4"}
+
+	if err := rl.Register(ctx, "s", []config.API{perMinute}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if resp, err := rl.Check(ctx, req); err != nil || !resp.Allowed {
+			t.Fatalf("request %d: %+v, %v", i, resp, err)
+		}
+	}
+
+	if err := rl.Register(ctx, "s", []config.API{perMinute, perHour}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rl.Check(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Allowed {
+		t.Errorf("allowed after adding a rule, the per-minute counter was reset: %+v", resp)
+	}
+}
+
+func TestEditingLimitKeepsCounters(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory(100)
+	defer store.Close()
+	rl := New(store)
+
+	rule := config.API{Path: "/a", Algorithm: "fixed_window", KeyStrategy: "ip", Limit: 5, WindowSeconds: 3600}
+	req := CheckRequest{Service: "s", API: "/a", IP: "1.2.3.4"}
+
+	if err := rl.Register(ctx, "s", []config.API{rule}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if resp, err := rl.Check(ctx, req); err != nil || !resp.Allowed {
+			t.Fatalf("request %d: %+v, %v", i, resp, err)
+		}
+	}
+
+	rule.Limit, rule.Burst = 3, 3
+	if err := rl.Register(ctx, "s", []config.API{rule}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rl.Check(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Allowed {
+		t.Errorf("allowed after tightening the limit, the counter was reset: %+v", resp)
+	}
+}
+
+func TestServiceRulesCachedForLocalPolicy(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory(100)
+	defer store.Close()
+	rl := New(store)
+
+	rules := []config.API{{Path: "/a", Algorithm: "fixed_window", KeyStrategy: "ip", Limit: 10, WindowSeconds: 60}}
+	for _, svc := range []string{"open", "local"} {
+		if err := rl.Register(ctx, svc, rules); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rl.SetFailurePolicy(config.FailureConfig{Policy: config.FailOpen, Services: map[string]string{"local": config.FailLocal}})
+
+	for _, svc := range []string{"open", "local"} {
+		if _, err := rl.Check(ctx, CheckRequest{Service: svc, API: "/a", IP: "1.2.3.4"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := rl.rules["open"]; ok {
+		t.Error("rules cached for a service without the local policy")
+	}
+	if _, ok := rl.rules["local"]; !ok {
+		t.Error("rules not cached for a service with the local policy")
+	}
+
+	rl.SetFailurePolicy(config.FailureConfig{Policy: config.FailOpen})
+	if _, err := rl.Check(ctx, CheckRequest{Service: "local", API: "/a", IP: "1.2.3.4"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rl.rules["local"]; ok {
+		t.Error("rules still cached after the local policy was removed")
+	}
+}
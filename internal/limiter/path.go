@@ -0,0 +1,95 @@
+package limiter
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+// matchRules returns the rules that apply to a request: those of the most
+// specific path template matching path, narrowed to the rules listing method
+// if there are any, or else its method-agnostic rules. It returns nil if no
+// rule applies.
+func matchRules(apis []config.API, path, method string) []config.API {
+	best, found := "", false
+	for _, api := range apis {
+		if len(api.Methods) > 0 && !hasMethod(api, method) {
+			continue
+		}
+		if matchPath(api.Path, path) && (!found || moreSpecific(api.Path, best)) {
+			best, found = api.Path, true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	var specific, agnostic []config.API
+	for _, api := range apis {
+		switch {
+		case api.Path != best:
+		case len(api.Methods) == 0:
+			agnostic = append(agnostic, api)
+		case hasMethod(api, method):
+			specific = append(specific, api)
+		}
+	}
+	if len(specific) > 0 {
+		return specific
+	}
+	return agnostic
+}
+
+func hasMethod(api config.API, method string) bool {
+	return slices.ContainsFunc(api.Methods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	})
+}
+
+func matchPath(template, path string) bool {
+	if template == path {
+		return true
+	}
+
+	ts := strings.Split(template, "/")
+	ps := strings.Split(path, "/")
+	for i, t := range ts {
+		if t == "**" {
+			return true
+		}
+		if i >= len(ps) || !isWildcard(t) && t != ps[i] {
+			return false
+		}
+	}
+	return len(ts) == len(ps)
+}
+
+func isWildcard(segment string) bool {
+	return segment == "*" || strings.HasPrefix(segment, "{")
+}
+
+// segmentRank orders template segments from least to most specific.
+func segmentRank(segment string) int {
+	switch {
+	case segment == "**":
+		return 0
+	case isWildcard(segment):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// moreSpecific reports whether template a wins over b when both match a
+// path. The first segment where they differ in kind decides: a literal beats
+// a single-segment wildcard, which beats **. Otherwise the longer one wins.
+func moreSpecific(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if ra, rb := segmentRank(as[i]), segmentRank(bs[i]); ra != rb {
+			return ra > rb
+		}
+	}
+	return len(as) > len(bs)
+}
@@ -0,0 +1,129 @@
+package limiter
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		want     bool
+	}{
+		{"/users", "/users", true},
+		{"/users", "/orders", false},
+		{"/users/{id}", "/users/42", true},
+		{"/users/{id}", "/users", false},
+		{"/users/{id}", "/users/42/orders", false},
+		{"/users/*/orders", "/users/42/orders", true},
+		{"/users/*/orders", "/users/42/items", false},
+		{"/users/**", "/users/42/orders/7", true},
+		{"/users/**", "/users/42", true},
+		{"/users/**", "/users", true},
+		{"/users/**", "/orders/42", false},
+		{"/**", "/anything/at/all", true},
+		{"/users/{id}/orders/{oid}", "/users/1/orders/2", true},
+	}
+	for _, tt := range tests {
+		if got := matchPath(tt.template, tt.path); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.template, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMoreSpecific(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/users/me", "/users/{id}", true},
+		{"/users/{id}", "/users/me", false},
+		{"/users/{id}", "/users/*", false},
+		{"/users/*", "/users/{id}", false},
+		{"/users/*", "/users/**", true},
+		{"/users/{id}", "/users/**", true},
+		{"/users/**", "/users/{id}", false},
+		{"/users/me", "/**", true},
+		{"/users/{id}/orders", "/users/**", true},
+		{"/users/{id}/**", "/users/**", true},
+		{"/users/{id}/orders", "/users/{id}/{sub}", true},
+	}
+	for _, tt := range tests {
+		if got := moreSpecific(tt.a, tt.b); got != tt.want {
+			t.Errorf("moreSpecific(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatchRulesPrecedence(t *testing.T) {
+	rules := []config.API{
+		{Path: "/**", Limit: 1},
+		{Path: "/users/**", Limit: 2},
+		{Path: "/users/*", Limit: 3},
+		{Path: "/users/{id}/orders", Limit: 4},
+		{Path: "/users/me", Limit: 5},
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/users/me", 5},
+		{"/users/42", 3},
+		{"/users/42/orders", 4},
+		{"/users/42/items", 2},
+		{"/users", 2},
+		{"/orders/7", 1},
+	}
+	for _, tt := range tests {
+		got := matchRules(rules, tt.path, "GET")
+		if len(got) != 1 || got[0].Limit != tt.want {
+			t.Errorf("matchRules(%q) = %+v, want the rule with limit %d", tt.path, got, tt.want)
+		}
+	}
+
+	if got := matchRules(rules[3:], "/users/42/orders/7", "GET"); got != nil {
+		t.Errorf("matchRules with a longer path = %+v, want none", got)
+	}
+}
+
+func TestMatchRulesMethods(t *testing.T) {
+	rules := []config.API{
+		{Path: "/orders", Limit: 1},
+		{Path: "/orders", Methods: []string{"POST"}, Limit: 2},
+		{Path: "/orders", Methods: []string{"post", "PUT"}, Limit: 3},
+		{Path: "/orders/pending", Methods: []string{"DELETE"}, Limit: 4},
+		{Path: "/orders/*", Limit: 5},
+		{Path: "/refunds", Methods: []string{"POST"}, Limit: 6},
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		method string
+		want   []int
+	}{
+		{"method rules replace agnostic ones", "/orders", "POST", []int{2, 3}},
+		{"method is case-insensitive", "/orders", "put", []int{3}},
+		{"other method falls back to agnostic", "/orders", "GET", []int{1}},
+		{"empty method falls back to agnostic", "/orders", "", []int{1}},
+		{"method-only rule wins on its path", "/orders/pending", "DELETE", []int{4}},
+		{"non-matching method skips the path", "/orders/pending", "GET", []int{5}},
+		{"no agnostic rule to fall back to", "/refunds", "GET", nil},
+		{"empty method and no agnostic rule", "/refunds", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, api := range matchRules(rules, tt.path, tt.method) {
+				got = append(got, api.Limit)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matchRules(%q, %q) = limits %v, want %v", tt.path, tt.method, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	"github.com/larrasket/hlimiter/internal/config"
+	"github.com/larrasket/hlimiter/internal/storage"
+)
+
+// Reconcile brings the store in line with the services declared in the
+// config file. prev is the previously applied set of declared services (nil
+// on startup); services dropped from the file are removed from the store.
+// When the store holds a different version of a service that was not edited
+// in the file, onConflict decides whether the file wins, the stored version is
+// kept, or the whole reconcile is rejected. All changes are applied in a
+// single transaction.
+func (rl *RateLimiter) Reconcile(ctx context.Context, prev, next []config.Service, onConflict string) error {
+	prevAPIs := make(map[string][]config.API, len(prev))
+	for _, svc := range prev {
+		prevAPIs[svc.Name] = svc.APIs
+	}
+
+	upsert := make(map[string][]config.API)
+	var remove []string
+
+	for _, svc := range next {
+		stored, err := rl.store.GetServiceConfig(ctx, svc.Name)
+		if err != nil && !errors.Is(err, storage.ErrServiceNotFound) {
+			return fmt.Errorf("load %s: %w", svc.Name, err)
+		}
+
+		if err == nil && reflect.DeepEqual(stored, svc.APIs) {
+			continue
+		}
+
+		old, declared := prevAPIs[svc.Name]
+		edited := declared && !reflect.DeepEqual(old, svc.APIs)
+
+		if err == nil && !edited {
+			if onConflict == config.SeedKeep {
+				slog.Warn("service registered with different config, keeping stored version", "service", svc.Name)
+				continue
+			}
+			if onConflict == config.SeedFail {
+				return fmt.Errorf("service %s registered with different config", svc.Name)
+			}
+		}
+
+		upsert[svc.Name] = svc.APIs
+	}
+
+	declared := make(map[string]bool, len(next))
+	for _, svc := range next {
+		declared[svc.Name] = true
+	}
+	for _, svc := range prev {
+		if !declared[svc.Name] {
+			remove = append(remove, svc.Name)
+		}
+	}
+
+	if len(upsert) == 0 && len(remove) == 0 {
+		slog.Info("declared services up to date", "services", len(next))
+		return nil
+	}
+
+	if err := rl.store.ApplyServices(ctx, upsert, remove); err != nil {
+		return err
+	}
+
+	for name, apis := range upsert {
+		if _, ok := prevAPIs[name]; ok {
+			slog.Info("service config updated", "service", name, "apis", len(apis))
+		} else {
+			slog.Info("service config applied", "service", name, "apis", len(apis))
+		}
+	}
+	for _, name := range remove {
+		slog.Info("service config removed", "service", name)
+	}
+	return nil
+}
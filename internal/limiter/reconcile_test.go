@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/larrasket/hlimiter/internal/config"
+	"github.com/larrasket/hlimiter/internal/storage"
+)
+
+func apis(limit int) []config.API {
+	return []config.API{{Path: "/a", Algorithm: "fixed_window", KeyStrategy: "ip", Limit: limit, WindowSeconds: 60}}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     map[string][]config.API
+		prev       []config.Service
+		next       []config.Service
+		onConflict string
+		wantErr    bool
+		want       map[string][]config.API
+	}{
+		{
+			name:       "new service",
+			next:       []config.Service{{Name: "s", APIs: apis(1)}},
+			onConflict: config.SeedFail,
+			want:       map[string][]config.API{"s": apis(1)},
+		},
+		{
+			name:       "overwrite",
+			stored:     map[string][]config.API{"s": apis(1)},
+			next:       []config.Service{{Name: "s", APIs: apis(2)}},
+			onConflict: config.SeedOverwrite,
+			want:       map[string][]config.API{"s": apis(2)},
+		},
+		{
+			name:       "keep",
+			stored:     map[string][]config.API{"s": apis(1)},
+			next:       []config.Service{{Name: "s", APIs: apis(2)}},
+			onConflict: config.SeedKeep,
+			want:       map[string][]config.API{"s": apis(1)},
+		},
+		{
+			name:       "fail",
+			stored:     map[string][]config.API{"s": apis(1)},
+			next:       []config.Service{{Name: "s", APIs: apis(2)}},
+			onConflict: config.SeedFail,
+			wantErr:    true,
+			want:       map[string][]config.API{"s": apis(1)},
+		},
+		{
+			name:       "fail applies nothing",
+			stored:     map[string][]config.API{"s": apis(1)},
+			next:       []config.Service{{Name: "new", APIs: apis(3)}, {Name: "s", APIs: apis(2)}},
+			onConflict: config.SeedFail,
+			wantErr:    true,
+			want:       map[string][]config.API{"s": apis(1)},
+		},
+		{
+			name:       "unchanged service is not a conflict",
+			stored:     map[string][]config.API{"s": apis(1)},
+			next:       []config.Service{{Name: "s", APIs: apis(1)}},
+			onConflict: config.SeedFail,
+			want:       map[string][]config.API{"s": apis(1)},
+		},
+		{
+			name:       "edited in file wins over the store",
+			stored:     map[string][]config.API{"s": apis(5)},
+			prev:       []config.Service{{Name: "s", APIs: apis(1)}},
+			next:       []config.Service{{Name: "s", APIs: apis(2)}},
+			onConflict: config.SeedFail,
+			want:       map[string][]config.API{"s": apis(2)},
+		},
+		{
+			name:       "unedited in file is still a conflict",
+			stored:     map[string][]config.API{"s": apis(5)},
+			prev:       []config.Service{{Name: "s", APIs: apis(1)}},
+			next:       []config.Service{{Name: "s", APIs: apis(1)}},
+			onConflict: config.SeedKeep,
+			want:       map[string][]config.API{"s": apis(5)},
+		},
+		{
+			name:       "dropped service is removed",
+			stored:     map[string][]config.API{"s": apis(1), "t": apis(1), "other": apis(1)},
+			prev:       []config.Service{{Name: "s", APIs: apis(1)}, {Name: "t", APIs: apis(1)}},
+			next:       []config.Service{{Name: "s", APIs: apis(1)}},
+			onConflict: config.SeedOverwrite,
+			want:       map[string][]config.API{"s": apis(1), "other": apis(1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := storage.NewMemory(100)
+			defer store.Close()
+			for name, a := range tt.stored {
+				if err := store.RegisterService(ctx, name, a); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := New(store).Reconcile(ctx, tt.prev, tt.next, tt.onConflict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile = %v, want error %v", err, tt.wantErr)
+			}
+
+			got, err := store.GetAllServices(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stored services = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
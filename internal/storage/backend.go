@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+const reservationKeyPrefix = "rlres:"
+
+var ErrReservationNotFound = errors.New("reservation not found")
+
+// Limit is a single rule evaluated against one key. Cost is the number of
+// units the request consumes.
+type Limit struct {
+	Key       string
+	Algorithm string
+	Limit     int
+	Burst     int
+	WindowMs  int64
+	Cost      int
+}
+
+// Result is the decision for one Limit. RetryAfterMs is how long a denied
+// request has to wait before its cost fits, or -1 if it never will.
+type Result struct {
+	Allowed      bool
+	Remaining    int
+	ResetAt      int64
+	DelayMs      int64
+	RetryAfterMs int64
+}
+
+// Backend is the state store behind the limiter: the per-key algorithms plus
+// the registered service configs.
+type Backend interface {
+	// Evaluate checks all limits atomically and records the request against
+	// every one of them only if all allow it. With a positive reserveMs the
+	// recorded request is kept for Refund for that long, but no longer than
+	// its limits' state, and its reservation ID is returned; the ID is empty
+	// when the request was denied or reserveMs is 0.
+	Evaluate(ctx context.Context, limits []Limit, reserveMs int64) ([]Result, string, error)
+	// Peek returns what Evaluate would decide without recording anything.
+	Peek(ctx context.Context, limits []Limit) ([]Result, error)
+	// Refund gives units of a recorded request back to its limits, all that
+	// are left when units is 0, and returns how many were refunded.
+	Refund(ctx context.Context, reservationID string, units int) (int, error)
+
+	AcquireLease(ctx context.Context, key, leaseID string, limit int, ttlMs int64) (bool, int, int64, error)
+	ReleaseLease(ctx context.Context, key, leaseID string) (bool, error)
+
+	RegisterService(ctx context.Context, serviceName string, apis []config.API) error
+	ApplyServices(ctx context.Context, upsert map[string][]config.API, remove []string) error
+	GetServiceConfig(ctx context.Context, serviceName string) ([]config.API, error)
+	GetAllServices(ctx context.Context) (map[string][]config.API, error)
+
+	Close() error
+}
+
+var _ Backend = (*RedisStore)(nil)
+
+func allAllowed(results []Result) bool {
+	for _, r := range results {
+		if !r.Allowed {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+// newReservationID returns a random ID that carries the Redis Cluster hash
+// tag of the limits' keys, so the reservation record shares their slot.
+func newReservationID(limits []Limit) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hashTag(limits) + hex.EncodeToString(b), nil
+}
+
+// hashTag returns the {...} hash tag of the first limit's key, if any.
+func hashTag(limits []Limit) string {
+	if len(limits) == 0 {
+		return ""
+	}
+	key := limits[0].Key
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return ""
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return ""
+	}
+	return key[start : start+end+2]
+}
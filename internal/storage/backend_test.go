@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// backends returns a fresh instance of every Backend, so the same cases can
+// check that they agree.
+func backends(t *testing.T) map[string]Backend {
+	t.Helper()
+	m := miniredis.RunT(t)
+	r, err := NewRedis(m.Addr(), "", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemory(100)
+	t.Cleanup(func() {
+		r.Close()
+		mem.Close()
+	})
+	return map[string]Backend{"redis": r, "memory": mem}
+}
+
+func TestBackendAllowedCount(t *testing.T) {
+	ctx := context.Background()
+
+	for _, algorithm := range windowAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			for name, b := range backends(t) {
+				limits := []Limit{{Key: "k", Algorithm: algorithm, Limit: 4, Burst: 4, WindowMs: 10000, Cost: 1}}
+
+				allowed := 0
+				var denied Result
+				for i := 0; i < 6; i++ {
+					results, _, err := b.Evaluate(ctx, limits, 0)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if results[0].Allowed {
+						allowed++
+						if want := 4 - allowed; results[0].Remaining != want {
+							t.Errorf("%s: request %d: remaining %d, want %d", name, i, results[0].Remaining, want)
+						}
+					} else {
+						denied = results[0]
+					}
+				}
+				if allowed != 4 {
+					t.Errorf("%s: allowed %d of 6, want 4", name, allowed)
+				}
+				if denied.RetryAfterMs <= 0 || denied.RetryAfterMs > 10000 {
+					t.Errorf("%s: retry_after %dms, want within the window", name, denied.RetryAfterMs)
+				}
+			}
+		})
+	}
+}
+
+func TestBackendCostOverLimit(t *testing.T) {
+	ctx := context.Background()
+
+	for _, algorithm := range windowAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			for name, b := range backends(t) {
+				limits := []Limit{{Key: "k", Algorithm: algorithm, Limit: 4, Burst: 4, WindowMs: 10000, Cost: 5}}
+
+				results, id, err := b.Evaluate(ctx, limits, 60000)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if results[0].Allowed || id != "" {
+					t.Errorf("%s: allowed a cost above the limit: %+v, reservation %q", name, results[0], id)
+				}
+				if results[0].RetryAfterMs != -1 {
+					t.Errorf("%s: retry_after %dms, want -1", name, results[0].RetryAfterMs)
+				}
+			}
+		})
+	}
+}
+
+func TestBackendRefund(t *testing.T) {
+	ctx := context.Background()
+
+	for _, algorithm := range windowAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			for name, b := range backends(t) {
+				limits := []Limit{{Key: "k", Algorithm: algorithm, Limit: 4, Burst: 4, WindowMs: 10000, Cost: 4}}
+
+				results, id, err := b.Evaluate(ctx, limits, 60000)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !results[0].Allowed || id == "" {
+					t.Fatalf("%s: first request: %+v, reservation %q", name, results[0], id)
+				}
+				if results, _ := b.Peek(ctx, limits); results[0].Allowed {
+					t.Fatalf("%s: allowed before the refund: %+v", name, results[0])
+				}
+
+				if n, err := b.Refund(ctx, id, 3); err != nil || n != 3 {
+					t.Fatalf("%s: partial refund: %d, %v", name, n, err)
+				}
+				if n, err := b.Refund(ctx, id, 0); err != nil || n != 1 {
+					t.Fatalf("%s: refund of the rest: %d, %v", name, n, err)
+				}
+				if _, err := b.Refund(ctx, id, 0); !errors.Is(err, ErrReservationNotFound) {
+					t.Errorf("%s: refund after all units were returned: %v", name, err)
+				}
+
+				results, _, err = b.Evaluate(ctx, limits, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !results[0].Allowed {
+					t.Errorf("%s: denied after the refund: %+v", name, results[0])
+				}
+			}
+		})
+	}
+}
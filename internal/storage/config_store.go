@@ -3,31 +3,25 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/redis/go-redis/v9"
+
 	"github.com/larrasket/hlimiter/internal/config"
 )
 
 const configKeyPrefix = "rlconfig:"
 
+var ErrServiceNotFound = errors.New("service not registered")
+
 func (r *RedisStore) RegisterService(ctx context.Context, serviceName string, apis []config.API) error {
 	if serviceName == "" {
 		return fmt.Errorf("service name cannot be empty")
 	}
 	
-	for _, api := range apis {
-		if api.Path == "" {
-			return fmt.Errorf("api path cannot be empty")
-		}
-		if api.Algorithm != "sliding_window" && api.Algorithm != "token_bucket" {
-			return fmt.Errorf("invalid algorithm: %s", api.Algorithm)
-		}
-		if api.Limit <= 0 {
-			return fmt.Errorf("limit must be positive")
-		}
-		if api.WindowSeconds <= 0 {
-			return fmt.Errorf("window_seconds must be positive")
-		}
+	if err := validateAPIs(apis); err != nil {
+		return err
 	}
 	
 	key := configKeyPrefix + serviceName
@@ -40,10 +34,64 @@ func (r *RedisStore) RegisterService(ctx context.Context, serviceName string, ap
 	return r.client.Set(ctx, key, data, 0).Err()
 }
 
+// ApplyServices writes and deletes several service configs in one
+// transaction, so readers never observe a partially applied change.
+func (r *RedisStore) ApplyServices(ctx context.Context, upsert map[string][]config.API, remove []string) error {
+	payloads := make(map[string][]byte, len(upsert))
+	for name, apis := range upsert {
+		if name == "" {
+			return fmt.Errorf("service name cannot be empty")
+		}
+		if err := validateAPIs(apis); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+		data, err := json.Marshal(apis)
+		if err != nil {
+			return fmt.Errorf("marshal failed: %w", err)
+		}
+		payloads[name] = data
+	}
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for name, data := range payloads {
+			pipe.Set(ctx, configKeyPrefix+name, data, 0)
+		}
+		for _, name := range remove {
+			pipe.Del(ctx, configKeyPrefix+name)
+		}
+		return nil
+	})
+	return err
+}
+
+func validateAPIs(apis []config.API) error {
+	for _, api := range apis {
+		if err := config.CheckPath(api); err != nil {
+			return err
+		}
+		if !config.ValidAlgorithm(api.Algorithm) {
+			return fmt.Errorf("invalid algorithm: %s", api.Algorithm)
+		}
+		if api.Limit <= 0 {
+			return fmt.Errorf("limit must be positive")
+		}
+		if err := config.CheckWindow(api); err != nil {
+			return err
+		}
+		if err := config.CheckKeyStrategy(api.KeyStrategy); err != nil {
+			return err
+		}
+	}
+	return config.CheckRules(apis)
+}
+
 func (r *RedisStore) GetServiceConfig(ctx context.Context, serviceName string) ([]config.API, error) {
 	key := configKeyPrefix + serviceName
 	
 	data, err := r.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrServiceNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,727 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/larrasket/hlimiter/internal/config"
+)
+
+const memoryShards = 32
+
+// MemoryStore is an in-process Backend with the same algorithm semantics as
+// RedisStore. Keys expire after the same idle TTL the Redis scripts use, and
+// each shard evicts its least recently used key once it holds more than its
+// share of maxKeys. Reservations are capped at maxKeys as well, dropping the
+// oldest first.
+type MemoryStore struct {
+	shards [memoryShards]*memoryShard
+
+	cfgMu    sync.RWMutex
+	services map[string][]config.API
+
+	resMu           sync.Mutex
+	maxReservations int
+	reservations    map[string]*list.Element
+	resOrder        *list.List
+
+	stop chan struct{}
+	once sync.Once
+}
+
+type memoryShard struct {
+	mu      sync.Mutex
+	maxKeys int
+	items   map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryEntry struct {
+	key      string
+	expireAt time.Time
+	value    any
+}
+
+// reservation remembers a committed request so it can be refunded.
+type reservation struct {
+	id       string
+	limits   []Limit
+	at       time.Time
+	left     int
+	expireAt time.Time
+}
+
+type windowState struct {
+	hits []int64
+}
+
+type fixedState struct {
+	bucket int64
+	count  int
+}
+
+type counterState struct {
+	bucket int64
+	cur    int
+	prev   int
+}
+
+type gcraState struct {
+	tat float64
+}
+
+type leakyState struct {
+	next float64
+}
+
+type leaseState struct {
+	leases map[string]int64
+}
+
+type bucketState struct {
+	tokens float64
+	last   int64
+}
+
+var _ Backend = (*MemoryStore)(nil)
+
+func NewMemory(maxKeys int) *MemoryStore {
+	if maxKeys <= 0 {
+		maxKeys = 100000
+	}
+	perShard := (maxKeys + memoryShards - 1) / memoryShards
+
+	m := &MemoryStore{
+		services:        make(map[string][]config.API),
+		maxReservations: maxKeys,
+		reservations:    make(map[string]*list.Element),
+		resOrder:        list.New(),
+		stop:            make(chan struct{}),
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{
+			maxKeys: perShard,
+			items:   make(map[string]*list.Element),
+			lru:     list.New(),
+		}
+	}
+
+	go m.janitor(30 * time.Second)
+	return m
+}
+
+func (m *MemoryStore) shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % memoryShards)
+}
+
+func (m *MemoryStore) shard(key string) *memoryShard {
+	return m.shards[m.shardIndex(key)]
+}
+
+// lockShards locks every shard holding one of the limits' keys, in index
+// order so concurrent evaluations cannot deadlock.
+func (m *MemoryStore) lockShards(limits []Limit) func() {
+	var held [memoryShards]bool
+	for _, l := range limits {
+		held[m.shardIndex(l.Key)] = true
+	}
+	for i, h := range held {
+		if h {
+			m.shards[i].mu.Lock()
+		}
+	}
+	return func() {
+		for i, h := range held {
+			if h {
+				m.shards[i].mu.Unlock()
+			}
+		}
+	}
+}
+
+// get returns the live value of key, or nil. The caller holds s.mu.
+func (s *memoryShard) get(key string, now time.Time) any {
+	el, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*memoryEntry)
+	if now.After(e.expireAt) {
+		return nil
+	}
+	return e.value
+}
+
+// set stores v under key, refreshing its expiry and LRU position and
+// evicting the least recently used keys beyond the shard's share of maxKeys.
+// The caller holds s.mu.
+func (s *memoryShard) set(key string, v any, expireAt time.Time) {
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*memoryEntry)
+		e.value = v
+		e.expireAt = expireAt
+		s.lru.MoveToFront(el)
+	} else {
+		s.items[key] = s.lru.PushFront(&memoryEntry{key: key, value: v, expireAt: expireAt})
+	}
+
+	for s.lru.Len() > s.maxKeys {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// update runs fn on the current value of key under the shard lock and stores
+// the value it returns with the given TTL.
+func (m *MemoryStore) update(key string, ttl time.Duration, fn func(v any) any) {
+	s := m.shard(key)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set(key, fn(s.get(key, now)), now.Add(ttl))
+}
+
+func (m *MemoryStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		m.sweep(time.Now())
+	}
+}
+
+// sweep drops the keys and reservations that expired before now.
+func (m *MemoryStore) sweep(now time.Time) {
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for key, el := range s.items {
+			if now.After(el.Value.(*memoryEntry).expireAt) {
+				s.lru.Remove(el)
+				delete(s.items, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	m.resMu.Lock()
+	for _, el := range m.reservations {
+		if now.After(el.Value.(*reservation).expireAt) {
+			m.dropReservation(el)
+		}
+	}
+	m.resMu.Unlock()
+}
+
+func idleTTL(windowMs int64) time.Duration {
+	return time.Duration(math.Ceil(float64(windowMs)*1.5)) * time.Millisecond
+}
+
+// toSec rounds a millisecond timestamp up to unix seconds.
+func toSec(ms float64) int64 {
+	return int64(math.Ceil(ms / 1000))
+}
+
+// memoryCheck is the in-process counterpart of an algorithm in
+// evaluateScript. It must not modify v; it returns the decision together
+// with the state to store, and its TTL, if the request is recorded.
+type memoryCheck func(v any, l Limit, now time.Time) (Result, any, time.Duration)
+
+var memoryAlgorithms = map[string]memoryCheck{
+	"sliding_window":         memSlidingWindow,
+	"token_bucket":           memTokenBucket,
+	"fixed_window":           memFixedWindow,
+	"sliding_window_counter": memSlidingWindowCounter,
+	"gcra":                   memGCRA,
+	"leaky_bucket":           memLeakyBucket,
+}
+
+func memSlidingWindow(v any, l Limit, now time.Time) (Result, any, time.Duration) {
+	nowMs := now.UnixMilli()
+	cutoff := nowMs - l.WindowMs
+	resetAt := toSec(float64(nowMs + l.WindowMs))
+
+	var hits []int64
+	if st, ok := v.(*windowState); ok {
+		for _, ts := range st.hits {
+			if ts > cutoff {
+				hits = append(hits, ts)
+			}
+		}
+	}
+
+	count := len(hits)
+	if count+l.Cost > l.Limit {
+		retry := int64(-1)
+		if l.Cost <= l.Limit {
+			sorted := slices.Sorted(slices.Values(hits))
+			retry = max(sorted[count+l.Cost-l.Limit-1]+l.WindowMs-nowMs, 0)
+		}
+		return Result{Remaining: max(l.Limit-count, 0), ResetAt: resetAt, RetryAfterMs: retry}, nil, 0
+	}
+	for range l.Cost {
+		hits = append(hits, nowMs)
+	}
+	next := &windowState{hits: hits}
+	return Result{Allowed: true, Remaining: l.Limit - count - l.Cost, ResetAt: resetAt}, next, idleTTL(l.WindowMs)
+}
+
+func memTokenBucket(v any, l Limit, now time.Time) (Result, any, time.Duration) {
+	nowMs := now.UnixMilli()
+	rate := float64(l.Limit) / float64(l.WindowMs)
+
+	tokens := float64(l.Burst)
+	if st, ok := v.(*bucketState); ok {
+		elapsed := float64(max(nowMs-st.last, 0))
+		tokens = math.Min(float64(l.Burst), st.tokens+elapsed*rate)
+	}
+
+	allowed := false
+	retry := int64(0)
+	if tokens >= float64(l.Cost) {
+		tokens -= float64(l.Cost)
+		allowed = true
+	} else if l.Cost > l.Burst {
+		retry = -1
+	} else {
+		retry = int64(math.Ceil((float64(l.Cost) - tokens) / rate))
+	}
+
+	res := Result{
+		Allowed:      allowed,
+		Remaining:    int(math.Floor(tokens)),
+		ResetAt:      toSec(float64(nowMs) + (float64(l.Burst)-tokens)/rate),
+		RetryAfterMs: retry,
+	}
+	return res, &bucketState{tokens: tokens, last: nowMs}, idleTTL(l.WindowMs)
+}
+
+func memFixedWindow(v any, l Limit, now time.Time) (Result, any, time.Duration) {
+	nowMs := now.UnixMilli()
+	bucket := nowMs / l.WindowMs
+	windowEnd := (bucket + 1) * l.WindowMs
+
+	count := 0
+	if st, ok := v.(*fixedState); ok && st.bucket == bucket {
+		count = st.count
+	}
+
+	if count+l.Cost > l.Limit {
+		retry := int64(-1)
+		if l.Cost <= l.Limit {
+			retry = windowEnd - nowMs
+		}
+		return Result{Remaining: max(l.Limit-count, 0), ResetAt: toSec(float64(windowEnd)), RetryAfterMs: retry}, nil, 0
+	}
+	next := &fixedState{bucket: bucket, count: count + l.Cost}
+	res := Result{Allowed: true, Remaining: l.Limit - count - l.Cost, ResetAt: toSec(float64(windowEnd))}
+	return res, next, time.Duration(l.WindowMs) * time.Millisecond
+}
+
+func memSlidingWindowCounter(v any, l Limit, now time.Time) (Result, any, time.Duration) {
+	nowMs := now.UnixMilli()
+	bucket := nowMs / l.WindowMs
+	windowEnd := (bucket + 1) * l.WindowMs
+
+	var cur, prev int
+	if st, ok := v.(*counterState); ok {
+		if st.bucket == bucket {
+			cur, prev = st.cur, st.prev
+		} else if st.bucket == bucket-1 {
+			prev = st.cur
+		}
+	}
+
+	weight := float64(windowEnd-nowMs) / float64(l.WindowMs)
+	estimated := float64(prev)*weight + float64(cur)
+
+	if estimated+float64(l.Cost) > float64(l.Limit) {
+		resetMs := windowEnd
+		if prev > 0 {
+			wait := int64(math.Ceil((estimated + float64(l.Cost) - float64(l.Limit)) * float64(l.WindowMs) / float64(prev)))
+			resetMs = min(nowMs+wait, windowEnd)
+		}
+		retry := int64(-1)
+		if l.Cost <= l.Limit {
+			retry = max(resetMs-nowMs, 0)
+		}
+		return Result{Remaining: max(int(math.Floor(float64(l.Limit)-estimated)), 0), ResetAt: toSec(float64(resetMs)), RetryAfterMs: retry}, nil, 0
+	}
+
+	next := &counterState{bucket: bucket, cur: cur + l.Cost, prev: prev}
+	res := Result{Allowed: true, Remaining: int(math.Floor(float64(l.Limit) - estimated - float64(l.Cost))), ResetAt: toSec(float64(windowEnd))}
+	return res, next, time.Duration(l.WindowMs*2) * time.Millisecond
+}
+
+func memGCRA(v any, l Limit, now time.Time) (Result, any, time.Duration) {
+	nowMs := float64(now.UnixMilli())
+	interval := float64(l.WindowMs) / float64(l.Limit)
+
+	tat := nowMs
+	if st, ok := v.(*gcraState); ok {
+		tat = math.Max(st.tat, nowMs)
+	}
+
+	newTAT := tat + interval*float64(l.Cost)
+	allowAt := newTAT - interval*float64(l.Burst)
+	if nowMs < allowAt {
+		available := max(int(math.Floor((nowMs-tat)/interval+float64(l.Burst))), 0)
+		retry := int64(-1)
+		if l.Cost <= l.Burst {
+			retry = int64(math.Ceil(allowAt - nowMs))
+		}
+		return Result{Remaining: available, ResetAt: toSec(tat), RetryAfterMs: retry}, nil, 0
+	}
+
+	res := Result{
+		Allowed:   true,
+		Remaining: int(math.Floor((nowMs - allowAt) / interval)),
+		ResetAt:   toSec(newTAT),
+	}
+	return res, &gcraState{tat: newTAT}, time.Duration(math.Ceil(newTAT-nowMs)) * time.Millisecond
+}
+
+func memLeakyBucket(v any, l Limit, now time.Time) (Result, any, time.Duration) {
+	nowMs := float64(now.UnixMilli())
+	interval := float64(l.WindowMs) / float64(l.Limit)
+
+	next := nowMs
+	if st, ok := v.(*leakyState); ok {
+		next = math.Max(st.next, nowMs)
+	}
+
+	delay := next - nowMs
+	waiting := int(math.Ceil(delay / interval))
+	if waiting+l.Cost > l.Burst {
+		retry := int64(-1)
+		if l.Cost <= l.Burst {
+			retry = max(int64(math.Ceil(next-float64(l.Burst-l.Cost)*interval-nowMs)), 0)
+		}
+		return Result{Remaining: max(l.Burst-waiting, 0), ResetAt: toSec(next), RetryAfterMs: retry}, nil, 0
+	}
+
+	next += interval * float64(l.Cost)
+	res := Result{
+		Allowed:   true,
+		Remaining: l.Burst - waiting - l.Cost,
+		ResetAt:   toSec(next),
+		DelayMs:   int64(math.Ceil(delay)),
+	}
+	return res, &leakyState{next: next}, time.Duration(math.Ceil(next-nowMs)) * time.Millisecond
+}
+
+func (m *MemoryStore) Evaluate(ctx context.Context, limits []Limit, reserveMs int64) ([]Result, string, error) {
+	now := time.Now()
+	results, err := m.evaluate(limits, now, true)
+	if err != nil || !allAllowed(results) || reserveMs <= 0 {
+		return results, "", err
+	}
+
+	id, err := newReservationID(limits)
+	if err != nil {
+		return nil, "", err
+	}
+	ttl := time.Second
+	for _, l := range limits {
+		ttl = max(ttl, idleTTL(l.WindowMs))
+	}
+	ttl = min(ttl, time.Duration(reserveMs)*time.Millisecond)
+
+	m.resMu.Lock()
+	defer m.resMu.Unlock()
+	res := &reservation{id: id, limits: limits, at: now, left: limits[0].Cost, expireAt: now.Add(ttl)}
+	m.reservations[id] = m.resOrder.PushBack(res)
+	for len(m.reservations) > m.maxReservations {
+		m.dropReservation(m.resOrder.Front())
+	}
+
+	return results, id, nil
+}
+
+// dropReservation forgets a reservation. The caller must hold resMu.
+func (m *MemoryStore) dropReservation(el *list.Element) {
+	m.resOrder.Remove(el)
+	delete(m.reservations, el.Value.(*reservation).id)
+}
+
+func (m *MemoryStore) Peek(ctx context.Context, limits []Limit) ([]Result, error) {
+	return m.evaluate(limits, time.Now(), false)
+}
+
+func (m *MemoryStore) evaluate(limits []Limit, now time.Time, commit bool) ([]Result, error) {
+	unlock := m.lockShards(limits)
+	defer unlock()
+
+	results := make([]Result, len(limits))
+	states := make([]any, len(limits))
+	ttls := make([]time.Duration, len(limits))
+	allowed := true
+
+	for i, l := range limits {
+		check, ok := memoryAlgorithms[l.Algorithm]
+		if !ok {
+			return nil, fmt.Errorf("unknown algorithm %s", l.Algorithm)
+		}
+		results[i], states[i], ttls[i] = check(m.shard(l.Key).get(l.Key, now), l, now)
+		if !results[i].Allowed {
+			allowed = false
+		}
+	}
+
+	if allowed && commit {
+		for i, l := range limits {
+			m.shard(l.Key).set(l.Key, states[i], now.Add(ttls[i]))
+		}
+	}
+
+	return results, nil
+}
+
+// memoryRefund gives n units of res back to the state of one of its limits.
+type memoryRefund func(v any, l Limit, res *reservation, n int, now time.Time) any
+
+var memoryRefunds = map[string]memoryRefund{
+	"sliding_window":         refundSlidingWindow,
+	"token_bucket":           refundTokenBucket,
+	"fixed_window":           refundFixedWindow,
+	"sliding_window_counter": refundSlidingWindowCounter,
+	"gcra":                   refundGCRA,
+	"leaky_bucket":           refundLeakyBucket,
+}
+
+func refundSlidingWindow(v any, l Limit, res *reservation, n int, now time.Time) any {
+	st, ok := v.(*windowState)
+	if !ok {
+		return v
+	}
+
+	at := res.at.UnixMilli()
+	hits := make([]int64, 0, len(st.hits))
+	for i := len(st.hits) - 1; i >= 0; i-- {
+		if n > 0 && st.hits[i] == at {
+			n--
+			continue
+		}
+		hits = append(hits, st.hits[i])
+	}
+	slices.Reverse(hits)
+	return &windowState{hits: hits}
+}
+
+func refundTokenBucket(v any, l Limit, res *reservation, n int, now time.Time) any {
+	st, ok := v.(*bucketState)
+	if !ok {
+		return v
+	}
+	return &bucketState{tokens: math.Min(float64(l.Burst), st.tokens+float64(n)), last: st.last}
+}
+
+func refundFixedWindow(v any, l Limit, res *reservation, n int, now time.Time) any {
+	st, ok := v.(*fixedState)
+	if !ok || st.bucket != res.at.UnixMilli()/l.WindowMs {
+		return v
+	}
+	return &fixedState{bucket: st.bucket, count: st.count - min(n, st.count)}
+}
+
+func refundSlidingWindowCounter(v any, l Limit, res *reservation, n int, now time.Time) any {
+	st, ok := v.(*counterState)
+	if !ok {
+		return v
+	}
+
+	bucket := res.at.UnixMilli() / l.WindowMs
+	next := *st
+	if st.bucket == bucket {
+		next.cur -= min(n, st.cur)
+	} else if st.bucket == bucket+1 {
+		next.prev -= min(n, st.prev)
+	}
+	return &next
+}
+
+func refundGCRA(v any, l Limit, res *reservation, n int, now time.Time) any {
+	st, ok := v.(*gcraState)
+	if !ok {
+		return v
+	}
+	interval := float64(l.WindowMs) / float64(l.Limit)
+	return &gcraState{tat: math.Max(st.tat-interval*float64(n), float64(now.UnixMilli()))}
+}
+
+func refundLeakyBucket(v any, l Limit, res *reservation, n int, now time.Time) any {
+	st, ok := v.(*leakyState)
+	if !ok {
+		return v
+	}
+	interval := float64(l.WindowMs) / float64(l.Limit)
+	return &leakyState{next: math.Max(st.next-interval*float64(n), float64(now.UnixMilli()))}
+}
+
+func (m *MemoryStore) Refund(ctx context.Context, reservationID string, units int) (int, error) {
+	now := time.Now()
+
+	m.resMu.Lock()
+	defer m.resMu.Unlock()
+
+	el, ok := m.reservations[reservationID]
+	if !ok {
+		return 0, ErrReservationNotFound
+	}
+	res := el.Value.(*reservation)
+	if now.After(res.expireAt) {
+		return 0, ErrReservationNotFound
+	}
+
+	n := res.left
+	if units > 0 && units < n {
+		n = units
+	}
+
+	unlock := m.lockShards(res.limits)
+	for _, l := range res.limits {
+		el, ok := m.shard(l.Key).items[l.Key]
+		if !ok {
+			continue
+		}
+		e := el.Value.(*memoryEntry)
+		if now.After(e.expireAt) {
+			continue
+		}
+		e.value = memoryRefunds[l.Algorithm](e.value, l, res, n, now)
+	}
+	unlock()
+
+	res.left -= n
+	if res.left <= 0 {
+		m.dropReservation(el)
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) AcquireLease(ctx context.Context, key, leaseID string, limit int, ttlMs int64) (bool, int, int64, error) {
+	now := time.Now().UnixMilli()
+	expiresAt := now + ttlMs
+
+	var allowed bool
+	var remaining int
+	m.update(key, time.Duration(ttlMs)*time.Millisecond, func(v any) any {
+		st, ok := v.(*leaseState)
+		if !ok {
+			st = &leaseState{leases: make(map[string]int64)}
+		}
+		for id, exp := range st.leases {
+			if exp <= now {
+				delete(st.leases, id)
+			}
+		}
+
+		count := len(st.leases)
+		if count < limit {
+			st.leases[leaseID] = expiresAt
+			allowed = true
+			remaining = limit - count - 1
+		}
+		return st
+	})
+
+	return allowed, remaining, toSec(float64(expiresAt)), nil
+}
+
+func (m *MemoryStore) ReleaseLease(ctx context.Context, key, leaseID string) (bool, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return false, nil
+	}
+	st, ok := el.Value.(*memoryEntry).value.(*leaseState)
+	if !ok {
+		return false, nil
+	}
+	exp, ok := st.leases[leaseID]
+	if !ok {
+		return false, nil
+	}
+	delete(st.leases, leaseID)
+	return exp > time.Now().UnixMilli(), nil
+}
+
+func (m *MemoryStore) RegisterService(ctx context.Context, serviceName string, apis []config.API) error {
+	if serviceName == "" {
+		return fmt.Errorf("service name cannot be empty")
+	}
+	if err := validateAPIs(apis); err != nil {
+		return err
+	}
+
+	m.cfgMu.Lock()
+	m.services[serviceName] = append([]config.API(nil), apis...)
+	m.cfgMu.Unlock()
+	return nil
+}
+
+func (m *MemoryStore) ApplyServices(ctx context.Context, upsert map[string][]config.API, remove []string) error {
+	for name, apis := range upsert {
+		if name == "" {
+			return fmt.Errorf("service name cannot be empty")
+		}
+		if err := validateAPIs(apis); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+
+	m.cfgMu.Lock()
+	defer m.cfgMu.Unlock()
+	for name, apis := range upsert {
+		m.services[name] = append([]config.API(nil), apis...)
+	}
+	for _, name := range remove {
+		delete(m.services, name)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetServiceConfig(ctx context.Context, serviceName string) ([]config.API, error) {
+	m.cfgMu.RLock()
+	defer m.cfgMu.RUnlock()
+
+	apis, ok := m.services[serviceName]
+	if !ok {
+		return nil, ErrServiceNotFound
+	}
+	return append([]config.API(nil), apis...), nil
+}
+
+func (m *MemoryStore) GetAllServices(ctx context.Context) (map[string][]config.API, error) {
+	m.cfgMu.RLock()
+	defer m.cfgMu.RUnlock()
+
+	result := make(map[string][]config.API, len(m.services))
+	for name, apis := range m.services {
+		result[name] = append([]config.API(nil), apis...)
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	return nil
+}
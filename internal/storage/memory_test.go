@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func fixedLimit(key string) []Limit {
+	return []Limit{{Key: key, Algorithm: "fixed_window", Limit: 1, Burst: 1, WindowMs: 3600000, Cost: 1}}
+}
+
+func TestMemoryEvictsPerShard(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(memoryShards)
+	defer m.Close()
+
+	// Each shard holds one key. a and b share a shard, c lives in another.
+	a := "a"
+	var b, c string
+	for i := 0; b == "" || c == ""; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if m.shardIndex(key) == m.shardIndex(a) {
+			b = key
+		} else if c == "" {
+			c = key
+		}
+	}
+
+	for _, key := range []string{a, c, b} {
+		if results, _, err := m.Evaluate(ctx, fixedLimit(key), 0); err != nil || !results[0].Allowed {
+			t.Fatalf("%s: %+v, %v", key, results, err)
+		}
+	}
+
+	if results, _ := m.Peek(ctx, fixedLimit(a)); !results[0].Allowed {
+		t.Errorf("%s was not evicted by %s", a, b)
+	}
+	for _, key := range []string{b, c} {
+		if results, _ := m.Peek(ctx, fixedLimit(key)); results[0].Allowed {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+}
+
+func TestMemorySweep(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(100)
+	defer m.Close()
+
+	limits := []Limit{{Key: "k", Algorithm: "sliding_window", Limit: 1, Burst: 1, WindowMs: 3600000, Cost: 1}}
+	_, id, err := m.Evaluate(ctx, limits, 60000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard := m.shard("k")
+
+	m.sweep(time.Now())
+	if _, ok := shard.items["k"]; !ok {
+		t.Fatal("key swept before its TTL")
+	}
+	if _, ok := m.reservations[id]; !ok {
+		t.Fatal("reservation swept before its TTL")
+	}
+
+	m.sweep(time.Now().Add(2 * time.Hour))
+	if len(shard.items) != 0 || shard.lru.Len() != 0 {
+		t.Errorf("expired key left after sweep: %d items, %d in lru", len(shard.items), shard.lru.Len())
+	}
+	if len(m.reservations) != 0 || m.resOrder.Len() != 0 {
+		t.Errorf("expired reservation left after sweep")
+	}
+}
+
+func TestMemoryReservationCap(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(2)
+	defer m.Close()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		_, id, err := m.Evaluate(ctx, fixedLimit(fmt.Sprintf("k%d", i)), 60000)
+		if err != nil || id == "" {
+			t.Fatalf("request %d: reservation %q, %v", i, id, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if _, err := m.Refund(ctx, ids[0], 0); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("oldest reservation kept over the cap: %v", err)
+	}
+	for _, id := range ids[1:] {
+		if n, err := m.Refund(ctx, id, 0); err != nil || n != 1 {
+			t.Errorf("refund %s: %d, %v", id, n, err)
+		}
+	}
+}
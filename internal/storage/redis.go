@@ -41,95 +41,428 @@ func NewRedis(addr, password string, db, poolSize int) (*RedisStore, error) {
 	return &RedisStore{client: client}, nil
 }
 
-var slidingWindowScript = redis.NewScript(`
-local key = KEYS[1]
-local now = tonumber(ARGV[1])
-local window = tonumber(ARGV[2])
-local limit = tonumber(ARGV[3])
-local reqid = ARGV[4]
-local cutoff = now - window
+// serverTime is the preamble of every script that reads the clock. It takes
+// the time from the Redis server, so all limiter instances share one clock
+// however far their hosts drift apart. A script that calls TIME is not
+// deterministic, so its effects are replicated instead of the script.
+const serverTime = `
+redis.replicate_commands()
+local server_time = redis.call('TIME')
+local now_ms = tonumber(server_time[1]) * 1000 + math.floor(tonumber(server_time[2]) / 1000)
+`
 
-redis.call('ZREMRANGEBYSCORE', key, 0, cutoff)
+// evaluateScript checks every limit of a request and only records the
+// request when all of them allow it. Each algorithm returns its decision and
+// a commit function; the checks never write, so a denied request leaves all
+// keys untouched; with the commit flag unset nothing is recorded at all,
+// which backs Peek. KEYS[1] is the reservation record written on commit so
+// the request can be refunded later; ARGV[3] is how long it is kept in
+// milliseconds, 0 to write none. Each limit then takes one KEY and five
+// ARGV: algorithm, limit, burst, window in milliseconds and cost. The window
+// based counters keep one field per window in a hash at that KEY, since only
+// the script knows the server time, and it touches no keys besides KEYS, as
+// Redis Cluster requires. All state is kept in milliseconds; reset_at is
+// returned in unix seconds.
+var evaluateScript = redis.NewScript(serverTime + `
+local reqid = ARGV[1]
+local commit_on_allow = ARGV[2] == '1'
+local reserve_ms = tonumber(ARGV[3])
 
-local count = redis.call('ZCARD', key)
-if count < limit then
-	redis.call('ZADD', key, now, reqid)
-	redis.call('EXPIRE', key, math.ceil(window * 1.5))
-	return {1, limit - count - 1, now + window}
-else
-	redis.call('EXPIRE', key, math.ceil(window * 1.5))
-	return {0, 0, now + window}
+local function to_sec(ms)
+	return math.ceil(ms / 1000)
+end
+
+-- zadd_units adds one sliding_window entry per unit of cost, batching them
+-- into variadic ZADDs so a large cost is a few calls rather than one each.
+local function zadd_units(key, cost)
+	local args = {}
+	for i = 1, cost do
+		args[#args + 1] = now_ms
+		args[#args + 1] = reqid .. ':' .. i
+		if #args >= 1000 or i == cost then
+			redis.call('ZADD', key, unpack(args))
+			args = {}
+		end
+	end
+end
+
+local algorithms = {}
+
+algorithms.sliding_window = function(key, limit, burst, window, cost)
+	local cutoff = now_ms - window
+	local count = redis.call('ZCOUNT', key, '(' .. cutoff, '+inf')
+	if count + cost > limit then
+		local retry = -1
+		if cost <= limit then
+			local oldest = redis.call('ZRANGEBYSCORE', key, '(' .. cutoff, '+inf', 'WITHSCORES', 'LIMIT', count + cost - limit - 1, 1)
+			retry = math.max(tonumber(oldest[2]) + window - now_ms, 0)
+		end
+		return 0, math.max(limit - count, 0), to_sec(now_ms + window), 0, retry
+	end
+	return 1, limit - count - cost, to_sec(now_ms + window), 0, 0, function()
+		redis.call('ZREMRANGEBYSCORE', key, 0, cutoff)
+		zadd_units(key, cost)
+		redis.call('PEXPIRE', key, math.ceil(window * 1.5))
+	end
+end
+
+algorithms.token_bucket = function(key, limit, burst, window, cost)
+	local rate = limit / window
+	local bucket = redis.call('HMGET', key, 'tokens', 'last')
+	local tokens = tonumber(bucket[1])
+	local last = tonumber(bucket[2])
+
+	if tokens == nil then
+		tokens = burst
+	else
+		tokens = math.min(burst, tokens + math.max(now_ms - last, 0) * rate)
+	end
+
+	local allowed = 0
+	local retry = 0
+	if tokens >= cost then
+		tokens = tokens - cost
+		allowed = 1
+	elseif cost > burst then
+		retry = -1
+	else
+		retry = math.ceil((cost - tokens) / rate)
+	end
+
+	local reset_at = to_sec(now_ms + (burst - tokens) / rate)
+	return allowed, math.floor(tokens), reset_at, 0, retry, function()
+		redis.call('HSET', key, 'tokens', tokens, 'last', now_ms)
+		redis.call('PEXPIRE', key, math.ceil(window * 1.5))
+	end
+end
+
+algorithms.fixed_window = function(key, limit, burst, window, cost)
+	local bucket = math.floor(now_ms / window)
+	local count = tonumber(redis.call('HGET', key, bucket) or '0')
+	local window_end = (bucket + 1) * window
+	if count + cost > limit then
+		local retry = cost > limit and -1 or window_end - now_ms
+		return 0, math.max(limit - count, 0), to_sec(window_end), 0, retry
+	end
+	return 1, limit - count - cost, to_sec(window_end), 0, 0, function()
+		if redis.call('HINCRBY', key, bucket, cost) == cost then
+			redis.call('HDEL', key, bucket - 1)
+			redis.call('PEXPIRE', key, window_end - now_ms)
+		end
+	end
+end
+
+algorithms.sliding_window_counter = function(key, limit, burst, window, cost)
+	local bucket = math.floor(now_ms / window)
+	local window_end = (bucket + 1) * window
+	local counts = redis.call('HMGET', key, bucket, bucket - 1)
+	local cur = tonumber(counts[1] or '0')
+	local prev = tonumber(counts[2] or '0')
+	local estimated = prev * (window_end - now_ms) / window + cur
+
+	if estimated + cost > limit then
+		local reset_ms = window_end
+		if prev > 0 then
+			reset_ms = math.min(now_ms + math.ceil((estimated + cost - limit) * window / prev), window_end)
+		end
+		local retry = cost > limit and -1 or math.max(reset_ms - now_ms, 0)
+		return 0, math.max(math.floor(limit - estimated), 0), to_sec(reset_ms), 0, retry
+	end
+	return 1, math.floor(limit - estimated - cost), to_sec(window_end), 0, 0, function()
+		redis.call('HINCRBY', key, bucket, cost)
+		redis.call('HDEL', key, bucket - 2)
+		redis.call('PEXPIRE', key, window * 2)
+	end
+end
+
+-- gcra keeps a single theoretical arrival time (TAT) per key. Each request
+-- advances the TAT by one emission interval and is admitted while the TAT
+-- stays within burst intervals of now.
+algorithms.gcra = function(key, limit, burst, window, cost)
+	local interval = window / limit
+	local tat = tonumber(redis.call('GET', key) or now_ms)
+	if tat < now_ms then
+		tat = now_ms
+	end
+
+	local new_tat = tat + interval * cost
+	local allow_at = new_tat - interval * burst
+	if now_ms < allow_at then
+		local available = math.max(math.floor((now_ms - tat) / interval + burst), 0)
+		local retry = cost > burst and -1 or math.ceil(allow_at - now_ms)
+		return 0, available, to_sec(tat), 0, retry
+	end
+	return 1, math.floor((now_ms - allow_at) / interval), to_sec(new_tat), 0, 0, function()
+		redis.call('SET', key, new_tat, 'PX', math.ceil(new_tat - now_ms))
+	end
+end
+
+-- leaky_bucket holds the time at which the next queued request may proceed;
+-- a request is admitted with a delay as long as fewer than burst requests
+-- are still pending ahead of it.
+algorithms.leaky_bucket = function(key, limit, burst, window, cost)
+	local interval = window / limit
+	local next_free = tonumber(redis.call('GET', key) or now_ms)
+	if next_free < now_ms then
+		next_free = now_ms
+	end
+
+	local delay = next_free - now_ms
+	local waiting = math.ceil(delay / interval)
+	if waiting + cost > burst then
+		local retry = -1
+		if cost <= burst then
+			retry = math.max(math.ceil(next_free - (burst - cost) * interval - now_ms), 0)
+		end
+		return 0, math.max(burst - waiting, 0), to_sec(next_free), 0, retry
+	end
+
+	local new_next = next_free + interval * cost
+	return 1, burst - waiting - cost, to_sec(new_next), math.ceil(delay), 0, function()
+		redis.call('SET', key, new_next, 'PX', math.ceil(new_next - now_ms))
+	end
+end
+
+local results = {}
+local commits = {}
+local record = {reqid = reqid, limits = {}}
+local record_ttl = 1000
+local all_allowed = true
+
+for i = 1, #KEYS - 1 do
+	local a = 3 + (i - 1) * 5
+	local algorithm = ARGV[a + 1]
+	local check = algorithms[algorithm]
+	if check == nil then
+		return redis.error_reply('unknown algorithm ' .. algorithm)
+	end
+
+	local limit, burst, window, cost = tonumber(ARGV[a + 2]), tonumber(ARGV[a + 3]), tonumber(ARGV[a + 4]), tonumber(ARGV[a + 5])
+	local key = KEYS[i + 1]
+	local allowed, remaining, reset_at, delay, retry, commit = check(key, limit, burst, window, cost)
+	if allowed == 0 then
+		all_allowed = false
+	end
+
+	commits[i] = commit
+	record.left = cost
+	record_ttl = math.max(record_ttl, math.ceil(window * 1.5))
+	table.insert(record.limits, {algorithm = algorithm, key = key, bucket = math.floor(now_ms / window), limit = limit, burst = burst, window = window})
+	table.insert(results, allowed)
+	table.insert(results, remaining)
+	table.insert(results, reset_at)
+	table.insert(results, delay)
+	table.insert(results, retry)
+end
+
+if all_allowed and commit_on_allow then
+	for i = 1, #commits do
+		commits[i]()
+	end
+	if reserve_ms > 0 then
+		redis.call('SET', KEYS[1], cjson.encode(record), 'PX', math.min(reserve_ms, record_ttl))
+	end
 end
+
+return results
 `)
 
-func (r *RedisStore) SlidingWindow(ctx context.Context, key string, limit int, window int64) (bool, int, int64, error) {
-	now := time.Now()
-	nowUnix := now.Unix()
-	reqID := fmt.Sprintf("%d:%d", nowUnix, now.UnixNano())
-	
-	result, err := slidingWindowScript.Run(ctx, r.client, []string{key}, nowUnix, window, limit, reqID).Int64Slice()
+func (r *RedisStore) Evaluate(ctx context.Context, limits []Limit, reserveMs int64) ([]Result, string, error) {
+	// The ID also names the request's sliding_window entries, so it is
+	// needed even when no reservation is kept.
+	reqID, err := newReservationID(limits)
 	if err != nil {
-		return false, 0, 0, err
+		return nil, "", err
 	}
 
-	allowed := result[0] == 1
-	remaining := int(result[1])
-	resetAt := result[2]
+	results, err := r.evaluate(ctx, limits, reqID, true, reserveMs)
+	if err != nil || !allAllowed(results) || reserveMs <= 0 {
+		return results, "", err
+	}
+	return results, reqID, nil
+}
 
-	return allowed, remaining, resetAt, nil
+func (r *RedisStore) Peek(ctx context.Context, limits []Limit) ([]Result, error) {
+	return r.evaluate(ctx, limits, hashTag(limits)+"peek", false, 0)
 }
 
-var tokenBucketScript = redis.NewScript(`
-local key = KEYS[1]
-local now = tonumber(ARGV[1])
-local rate = tonumber(ARGV[2])
-local burst = tonumber(ARGV[3])
-local window = tonumber(ARGV[4])
-
-local bucket = redis.call('HMGET', key, 'tokens', 'last')
-local tokens = tonumber(bucket[1])
-local last = tonumber(bucket[2])
-
-if tokens == nil then
-	tokens = burst
-	last = now
-else
-	local elapsed = now - last
-	tokens = math.min(burst, tokens + elapsed * rate)
+func (r *RedisStore) evaluate(ctx context.Context, limits []Limit, reqID string, commit bool, reserveMs int64) ([]Result, error) {
+	if len(limits) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, 1+len(limits))
+	keys = append(keys, reservationKeyPrefix+reqID)
+	commitFlag := 0
+	if commit {
+		commitFlag = 1
+	}
+
+	args := make([]any, 0, 3+5*len(limits))
+	args = append(args, reqID, commitFlag, max(reserveMs, 0))
+
+	for _, l := range limits {
+		keys = append(keys, l.Key)
+		args = append(args, l.Algorithm, l.Limit, l.Burst, l.WindowMs, l.Cost)
+	}
+
+	raw, err := evaluateScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(limits))
+	for i := range results {
+		row := raw[i*5 : i*5+5]
+		results[i] = Result{
+			Allowed:      row[0] == 1,
+			Remaining:    int(row[1]),
+			ResetAt:      row[2],
+			DelayMs:      row[3],
+			RetryAfterMs: row[4],
+		}
+	}
+
+	return results, nil
+}
+
+// refundScript gives units of a committed request back to every limit it
+// was counted against, using the reservation record evaluateScript wrote.
+// ARGV[1] is the number of units to refund, 0 for all that are left.
+var refundScript = redis.NewScript(serverTime + `
+local data = redis.call('GET', KEYS[1])
+if not data then
+	return -1
 end
 
-local allowed = 0
-local remaining = math.floor(tokens)
-if tokens >= 1 then
-	tokens = tokens - 1
-	allowed = 1
-	remaining = math.floor(tokens)
+local record = cjson.decode(data)
+local n = record.left
+local units = tonumber(ARGV[1])
+if units > 0 and units < n then
+	n = units
 end
 
-redis.call('HMSET', key, 'tokens', tokens, 'last', now)
-redis.call('EXPIRE', key, math.ceil(window * 1.5))
+local function refund_counter(l)
+	local count = tonumber(redis.call('HGET', l.key, l.bucket))
+	if count then
+		redis.call('HINCRBY', l.key, l.bucket, -math.min(n, count))
+	end
+end
+
+local function refund_time(key, interval)
+	local t = tonumber(redis.call('GET', key))
+	if not t then
+		return
+	end
+	t = t - interval * n
+	if t > now_ms then
+		redis.call('SET', key, t, 'PX', math.ceil(t - now_ms))
+	else
+		redis.call('DEL', key)
+	end
+end
 
-local needed = burst - tokens
-local secs_until_full = needed / rate
-local reset_at = now + math.ceil(secs_until_full)
+local refunds = {}
 
-return {allowed, remaining, reset_at}
+refunds.sliding_window = function(l)
+	local members = {}
+	for i = record.left, record.left - n + 1, -1 do
+		members[#members + 1] = record.reqid .. ':' .. i
+		if #members >= 500 or i == record.left - n + 1 then
+			redis.call('ZREM', l.key, unpack(members))
+			members = {}
+		end
+	end
+end
+
+refunds.token_bucket = function(l)
+	local tokens = tonumber(redis.call('HGET', l.key, 'tokens'))
+	if tokens then
+		redis.call('HSET', l.key, 'tokens', math.min(l.burst, tokens + n))
+	end
+end
+
+refunds.fixed_window = function(l)
+	refund_counter(l)
+end
+
+refunds.sliding_window_counter = function(l)
+	refund_counter(l)
+end
+
+refunds.gcra = function(l)
+	refund_time(l.key, l.window / l.limit)
+end
+
+refunds.leaky_bucket = function(l)
+	refund_time(l.key, l.window / l.limit)
+end
+
+for _, l in ipairs(record.limits) do
+	refunds[l.algorithm](l)
+end
+
+record.left = record.left - n
+if record.left <= 0 then
+	redis.call('DEL', KEYS[1])
+else
+	redis.call('SET', KEYS[1], cjson.encode(record), 'KEEPTTL')
+end
+
+return n
 `)
 
-func (r *RedisStore) TokenBucket(ctx context.Context, key string, limit, burst int, window int64) (bool, int, int64, error) {
-	now := time.Now().Unix()
-	rate := float64(limit) / float64(window)
+func (r *RedisStore) Refund(ctx context.Context, reservationID string, units int) (int, error) {
+	n, err := refundScript.Run(ctx, r.client, []string{reservationKeyPrefix + reservationID}, units).Int()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, ErrReservationNotFound
+	}
+	return n, nil
+}
+
+// acquireLeaseScript tracks in-flight leases in a ZSET scored by their
+// expiry (milliseconds), so leases of crashed callers free their slot once
+// the TTL passes. It returns the lease's expiry along with the decision.
+var acquireLeaseScript = redis.NewScript(serverTime + `
+local key = KEYS[1]
+local ttl = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local lease = ARGV[3]
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now_ms)
+
+local count = redis.call('ZCARD', key)
+if count >= limit then
+	return {0, 0, 0}
+end
 
-	result, err := tokenBucketScript.Run(ctx, r.client, []string{key}, now, rate, burst, window).Int64Slice()
+redis.call('ZADD', key, now_ms + ttl, lease)
+redis.call('PEXPIRE', key, ttl)
+return {1, limit - count - 1, now_ms + ttl}
+`)
+
+func (r *RedisStore) AcquireLease(ctx context.Context, key, leaseID string, limit int, ttlMs int64) (bool, int, int64, error) {
+	result, err := acquireLeaseScript.Run(ctx, r.client, []string{key}, ttlMs, limit, leaseID).Int64Slice()
 	if err != nil {
 		return false, 0, 0, err
 	}
 
 	allowed := result[0] == 1
 	remaining := int(result[1])
-	resetAt := result[2]
+	expiresAt := (result[2] + 999) / 1000
+
+	return allowed, remaining, expiresAt, nil
+}
 
-	return allowed, remaining, resetAt, nil
+func (r *RedisStore) ReleaseLease(ctx context.Context, key, leaseID string) (bool, error) {
+	n, err := r.client.ZRem(ctx, key, leaseID).Result()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
 }
 
 func (r *RedisStore) Close() error {
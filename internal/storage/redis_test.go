@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+var windowAlgorithms = []string{
+	"sliding_window",
+	"token_bucket",
+	"fixed_window",
+	"sliding_window_counter",
+	"gcra",
+	"leaky_bucket",
+}
+
+// skewedInstances starts a Redis whose clock is set far from the local one
+// and returns two limiter instances sharing it. The scripts take the time
+// from TIME only, so the instances behave as if their clocks were skewed
+// against each other and against Redis.
+func skewedInstances(t *testing.T, serverTime time.Time) (*miniredis.Miniredis, [2]*RedisStore) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	m.SetTime(serverTime)
+
+	var stores [2]*RedisStore
+	for i := range stores {
+		s, err := NewRedis(m.Addr(), "", 0, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		stores[i] = s
+	}
+	return m, stores
+}
+
+func TestEvaluateUsesServerTime(t *testing.T) {
+	base := time.Date(2003, 7, 14, 9, 26, 53, 0, time.UTC)
+	ctx := context.Background()
+
+	for _, algorithm := range windowAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			m, stores := skewedInstances(t, base)
+			limits := []Limit{{Key: "k", Algorithm: algorithm, Limit: 4, Burst: 4, WindowMs: 10000, Cost: 1}}
+
+			allowed := 0
+			for i := 0; i < 8; i++ {
+				results, _, err := stores[i%2].Evaluate(ctx, limits, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := results[0]
+				if r.Allowed {
+					allowed++
+				}
+				if r.ResetAt < base.Unix() || r.ResetAt > base.Add(20*time.Second).Unix() {
+					t.Errorf("request %d: reset_at %d not based on server time %d", i, r.ResetAt, base.Unix())
+				}
+
+				// Both instances must see the same state at the same server time.
+				a, err := stores[0].Peek(ctx, limits)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := stores[1].Peek(ctx, limits)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if a[0] != b[0] {
+					t.Errorf("request %d: instances disagree: %+v vs %+v", i, a[0], b[0])
+				}
+			}
+			if allowed != 4 {
+				t.Errorf("allowed %d of 8, want 4", allowed)
+			}
+
+			m.SetTime(base.Add(25 * time.Second))
+			results, _, err := stores[1].Evaluate(ctx, limits, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !results[0].Allowed {
+				t.Errorf("denied after the server clock moved past the window: %+v", results[0])
+			}
+		})
+	}
+}
+
+func TestRefundUsesServerTime(t *testing.T) {
+	base := time.Date(2003, 7, 14, 9, 26, 53, 0, time.UTC)
+	ctx := context.Background()
+
+	for _, algorithm := range windowAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			m, stores := skewedInstances(t, base)
+			limits := []Limit{{Key: "k", Algorithm: algorithm, Limit: 2, Burst: 2, WindowMs: 10000, Cost: 2}}
+
+			results, id, err := stores[0].Evaluate(ctx, limits, 60000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !results[0].Allowed || id == "" {
+				t.Fatalf("first request: %+v, reservation %q", results[0], id)
+			}
+
+			m.SetTime(base.Add(time.Second))
+			n, err := stores[1].Refund(ctx, id, 0)
+			if err != nil || n != 2 {
+				t.Fatalf("refund: %d, %v", n, err)
+			}
+
+			results, _, err = stores[1].Evaluate(ctx, limits, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !results[0].Allowed {
+				t.Errorf("denied after refund: %+v", results[0])
+			}
+		})
+	}
+}
+
+func TestEvaluateReservation(t *testing.T) {
+	ctx := context.Background()
+	m, stores := skewedInstances(t, time.Date(2003, 7, 14, 9, 26, 53, 0, time.UTC))
+	limits := []Limit{{Key: "k", Algorithm: "fixed_window", Limit: 10, Burst: 10, WindowMs: 3600000, Cost: 1}}
+
+	_, id, err := stores[0].Evaluate(ctx, limits, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "" {
+		t.Errorf("reservation %q returned without being asked for", id)
+	}
+	for _, key := range m.Keys() {
+		if strings.HasPrefix(key, reservationKeyPrefix) {
+			t.Errorf("reservation record %s written without being asked for", key)
+		}
+	}
+
+	_, id, err = stores[0].Evaluate(ctx, limits, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := m.TTL(reservationKeyPrefix + id); ttl != 5*time.Second {
+		t.Errorf("reservation ttl %s, want 5s", ttl)
+	}
+}
+
+func TestSlidingWindowLargeCost(t *testing.T) {
+	ctx := context.Background()
+	m, stores := skewedInstances(t, time.Date(2003, 7, 14, 9, 26, 53, 0, time.UTC))
+	limits := []Limit{{Key: "k", Algorithm: "sliding_window", Limit: 5000, Burst: 5000, WindowMs: 3600000, Cost: 2345}}
+
+	results, id, err := stores[0].Evaluate(ctx, limits, 60000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Allowed || results[0].Remaining != 5000-2345 {
+		t.Fatalf("evaluate: %+v", results[0])
+	}
+	if members, _ := m.ZMembers("k"); len(members) != 2345 {
+		t.Fatalf("%d entries, want 2345", len(members))
+	}
+
+	for _, units := range []int{1234, 0} {
+		if _, err := stores[0].Refund(ctx, id, units); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Exists("k") {
+		if members, _ := m.ZMembers("k"); len(members) != 0 {
+			t.Errorf("%d entries left after a full refund", len(members))
+		}
+	}
+}
+
+func TestEvaluateTouchesDeclaredKeysOnly(t *testing.T) {
+	base := time.Date(2003, 7, 14, 9, 26, 53, 0, time.UTC)
+	ctx := context.Background()
+	m, stores := skewedInstances(t, base)
+
+	declared := map[string]bool{}
+	var limits []Limit
+	for _, algorithm := range windowAlgorithms {
+		key := "{s:/a}:s:/a:" + algorithm
+		declared[key] = true
+		limits = append(limits, Limit{Key: key, Algorithm: algorithm, Limit: 4, Burst: 4, WindowMs: 10000, Cost: 1})
+	}
+
+	// Cross window boundaries so the counters move to new buckets.
+	var ids []string
+	for i := 0; i < 4; i++ {
+		m.SetTime(base.Add(time.Duration(i) * 6 * time.Second))
+		_, id, err := stores[0].Evaluate(ctx, limits, 60000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(id, "{s:/a}") {
+			t.Errorf("reservation %q does not carry the keys' hash tag", id)
+		}
+		declared[reservationKeyPrefix+id] = true
+		ids = append(ids, id)
+	}
+	if _, err := stores[0].Refund(ctx, ids[0], 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range m.Keys() {
+		if !declared[key] {
+			t.Errorf("script touched undeclared key %s", key)
+		}
+	}
+	for algorithm, windows := range map[string]int{"fixed_window": 1, "sliding_window_counter": 2} {
+		if fields, _ := m.HKeys("{s:/a}:s:/a:" + algorithm); len(fields) > windows {
+			t.Errorf("%s keeps old windows: %v", algorithm, fields)
+		}
+	}
+}
@@ -27,6 +27,12 @@ type CheckRequest struct {
 	Api           string                 `protobuf:"bytes,2,opt,name=api,proto3" json:"api,omitempty"`
 	Ip            string                 `protobuf:"bytes,3,opt,name=ip,proto3" json:"ip,omitempty"`
 	Headers       map[string]string      `protobuf:"bytes,4,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Cost          int32                  `protobuf:"varint,5,opt,name=cost,proto3" json:"cost,omitempty"`
+	Method        string                 `protobuf:"bytes,6,opt,name=method,proto3" json:"method,omitempty"`
+	PeerAddr      string                 `protobuf:"bytes,7,opt,name=peer_addr,json=peerAddr,proto3" json:"peer_addr,omitempty"`
+	Query         map[string]string      `protobuf:"bytes,8,rep,name=query,proto3" json:"query,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Attributes    map[string]string      `protobuf:"bytes,9,rep,name=attributes,proto3" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Refundable    bool                   `protobuf:"varint,10,opt,name=refundable,proto3" json:"refundable,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -89,11 +95,62 @@ func (x *CheckRequest) GetHeaders() map[string]string {
 	return nil
 }
 
+func (x *CheckRequest) GetCost() int32 {
+	if x != nil {
+		return x.Cost
+	}
+	return 0
+}
+
+func (x *CheckRequest) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetPeerAddr() string {
+	if x != nil {
+		return x.PeerAddr
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetQuery() map[string]string {
+	if x != nil {
+		return x.Query
+	}
+	return nil
+}
+
+func (x *CheckRequest) GetAttributes() map[string]string {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+func (x *CheckRequest) GetRefundable() bool {
+	if x != nil {
+		return x.Refundable
+	}
+	return false
+}
+
 type CheckResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Allowed       bool                   `protobuf:"varint,1,opt,name=allowed,proto3" json:"allowed,omitempty"`
 	Remaining     int32                  `protobuf:"varint,2,opt,name=remaining,proto3" json:"remaining,omitempty"`
 	ResetAt       int64                  `protobuf:"varint,3,opt,name=reset_at,json=resetAt,proto3" json:"reset_at,omitempty"`
+	DelayMs       int64                  `protobuf:"varint,4,opt,name=delay_ms,json=delayMs,proto3" json:"delay_ms,omitempty"`
+	ReservationId string                 `protobuf:"bytes,5,opt,name=reservation_id,json=reservationId,proto3" json:"reservation_id,omitempty"`
+	RetryAfterMs  int64                  `protobuf:"varint,6,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"`
+	Limit         int32                  `protobuf:"varint,7,opt,name=limit,proto3" json:"limit,omitempty"`
+	Path          string                 `protobuf:"bytes,8,opt,name=path,proto3" json:"path,omitempty"`
+	Algorithm     string                 `protobuf:"bytes,9,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	KeyStrategy   string                 `protobuf:"bytes,10,opt,name=key_strategy,json=keyStrategy,proto3" json:"key_strategy,omitempty"`
+	Degraded      bool                   `protobuf:"varint,11,opt,name=degraded,proto3" json:"degraded,omitempty"`
+	Reason        string                 `protobuf:"bytes,12,opt,name=reason,proto3" json:"reason,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -149,6 +206,165 @@ func (x *CheckResponse) GetResetAt() int64 {
 	return 0
 }
 
+func (x *CheckResponse) GetDelayMs() int64 {
+	if x != nil {
+		return x.DelayMs
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetReservationId() string {
+	if x != nil {
+		return x.ReservationId
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetRetryAfterMs() int64 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetKeyStrategy() string {
+	if x != nil {
+		return x.KeyStrategy
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetDegraded() bool {
+	if x != nil {
+		return x.Degraded
+	}
+	return false
+}
+
+func (x *CheckResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type RefundRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ReservationId string                 `protobuf:"bytes,1,opt,name=reservation_id,json=reservationId,proto3" json:"reservation_id,omitempty"`
+	Units         int32                  `protobuf:"varint,2,opt,name=units,proto3" json:"units,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RefundRequest) Reset() {
+	*x = RefundRequest{}
+	mi := &file_proto_limiter_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RefundRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RefundRequest) ProtoMessage() {}
+
+func (x *RefundRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_limiter_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RefundRequest.ProtoReflect.Descriptor instead.
+func (*RefundRequest) Descriptor() ([]byte, []int) {
+	return file_proto_limiter_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RefundRequest) GetReservationId() string {
+	if x != nil {
+		return x.ReservationId
+	}
+	return ""
+}
+
+func (x *RefundRequest) GetUnits() int32 {
+	if x != nil {
+		return x.Units
+	}
+	return 0
+}
+
+type RefundResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Refunded      int32                  `protobuf:"varint,1,opt,name=refunded,proto3" json:"refunded,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RefundResponse) Reset() {
+	*x = RefundResponse{}
+	mi := &file_proto_limiter_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RefundResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RefundResponse) ProtoMessage() {}
+
+func (x *RefundResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_limiter_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RefundResponse.ProtoReflect.Descriptor instead.
+func (*RefundResponse) Descriptor() ([]byte, []int) {
+	return file_proto_limiter_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RefundResponse) GetRefunded() int32 {
+	if x != nil {
+		return x.Refunded
+	}
+	return 0
+}
+
 type RegisterRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Service       string                 `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
@@ -159,7 +375,7 @@ type RegisterRequest struct {
 
 func (x *RegisterRequest) Reset() {
 	*x = RegisterRequest{}
-	mi := &file_proto_limiter_proto_msgTypes[2]
+	mi := &file_proto_limiter_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -171,7 +387,7 @@ func (x *RegisterRequest) String() string {
 func (*RegisterRequest) ProtoMessage() {}
 
 func (x *RegisterRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_limiter_proto_msgTypes[2]
+	mi := &file_proto_limiter_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -184,7 +400,7 @@ func (x *RegisterRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RegisterRequest.ProtoReflect.Descriptor instead.
 func (*RegisterRequest) Descriptor() ([]byte, []int) {
-	return file_proto_limiter_proto_rawDescGZIP(), []int{2}
+	return file_proto_limiter_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *RegisterRequest) GetService() string {
@@ -209,13 +425,16 @@ type APIConfig struct {
 	Limit         int32                  `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
 	WindowSeconds int32                  `protobuf:"varint,5,opt,name=window_seconds,json=windowSeconds,proto3" json:"window_seconds,omitempty"`
 	Burst         int32                  `protobuf:"varint,6,opt,name=burst,proto3" json:"burst,omitempty"`
+	WindowMs      int64                  `protobuf:"varint,7,opt,name=window_ms,json=windowMs,proto3" json:"window_ms,omitempty"`
+	KeyPath       string                 `protobuf:"bytes,8,opt,name=key_path,json=keyPath,proto3" json:"key_path,omitempty"`
+	Methods       []string               `protobuf:"bytes,9,rep,name=methods,proto3" json:"methods,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *APIConfig) Reset() {
 	*x = APIConfig{}
-	mi := &file_proto_limiter_proto_msgTypes[3]
+	mi := &file_proto_limiter_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -227,7 +446,7 @@ func (x *APIConfig) String() string {
 func (*APIConfig) ProtoMessage() {}
 
 func (x *APIConfig) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_limiter_proto_msgTypes[3]
+	mi := &file_proto_limiter_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -240,7 +459,7 @@ func (x *APIConfig) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use APIConfig.ProtoReflect.Descriptor instead.
 func (*APIConfig) Descriptor() ([]byte, []int) {
-	return file_proto_limiter_proto_rawDescGZIP(), []int{3}
+	return file_proto_limiter_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *APIConfig) GetPath() string {
@@ -285,6 +504,27 @@ func (x *APIConfig) GetBurst() int32 {
 	return 0
 }
 
+func (x *APIConfig) GetWindowMs() int64 {
+	if x != nil {
+		return x.WindowMs
+	}
+	return 0
+}
+
+func (x *APIConfig) GetKeyPath() string {
+	if x != nil {
+		return x.KeyPath
+	}
+	return ""
+}
+
+func (x *APIConfig) GetMethods() []string {
+	if x != nil {
+		return x.Methods
+	}
+	return nil
+}
+
 type RegisterResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
@@ -295,7 +535,7 @@ type RegisterResponse struct {
 
 func (x *RegisterResponse) Reset() {
 	*x = RegisterResponse{}
-	mi := &file_proto_limiter_proto_msgTypes[4]
+	mi := &file_proto_limiter_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -307,7 +547,7 @@ func (x *RegisterResponse) String() string {
 func (*RegisterResponse) ProtoMessage() {}
 
 func (x *RegisterResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_limiter_proto_msgTypes[4]
+	mi := &file_proto_limiter_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -320,7 +560,7 @@ func (x *RegisterResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RegisterResponse.ProtoReflect.Descriptor instead.
 func (*RegisterResponse) Descriptor() ([]byte, []int) {
-	return file_proto_limiter_proto_rawDescGZIP(), []int{4}
+	return file_proto_limiter_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *RegisterResponse) GetSuccess() bool {
@@ -337,39 +577,263 @@ func (x *RegisterResponse) GetMessage() string {
 	return ""
 }
 
+type AcquireResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Allowed       bool                   `protobuf:"varint,1,opt,name=allowed,proto3" json:"allowed,omitempty"`
+	Remaining     int32                  `protobuf:"varint,2,opt,name=remaining,proto3" json:"remaining,omitempty"`
+	LeaseId       string                 `protobuf:"bytes,3,opt,name=lease_id,json=leaseId,proto3" json:"lease_id,omitempty"`
+	ExpiresAt     int64                  `protobuf:"varint,4,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Degraded      bool                   `protobuf:"varint,5,opt,name=degraded,proto3" json:"degraded,omitempty"`
+	Reason        string                 `protobuf:"bytes,6,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AcquireResponse) Reset() {
+	*x = AcquireResponse{}
+	mi := &file_proto_limiter_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AcquireResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AcquireResponse) ProtoMessage() {}
+
+func (x *AcquireResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_limiter_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AcquireResponse.ProtoReflect.Descriptor instead.
+func (*AcquireResponse) Descriptor() ([]byte, []int) {
+	return file_proto_limiter_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *AcquireResponse) GetAllowed() bool {
+	if x != nil {
+		return x.Allowed
+	}
+	return false
+}
+
+func (x *AcquireResponse) GetRemaining() int32 {
+	if x != nil {
+		return x.Remaining
+	}
+	return 0
+}
+
+func (x *AcquireResponse) GetLeaseId() string {
+	if x != nil {
+		return x.LeaseId
+	}
+	return ""
+}
+
+func (x *AcquireResponse) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+func (x *AcquireResponse) GetDegraded() bool {
+	if x != nil {
+		return x.Degraded
+	}
+	return false
+}
+
+func (x *AcquireResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type ReleaseRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	LeaseId       string                 `protobuf:"bytes,1,opt,name=lease_id,json=leaseId,proto3" json:"lease_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseRequest) Reset() {
+	*x = ReleaseRequest{}
+	mi := &file_proto_limiter_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseRequest) ProtoMessage() {}
+
+func (x *ReleaseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_limiter_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseRequest.ProtoReflect.Descriptor instead.
+func (*ReleaseRequest) Descriptor() ([]byte, []int) {
+	return file_proto_limiter_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ReleaseRequest) GetLeaseId() string {
+	if x != nil {
+		return x.LeaseId
+	}
+	return ""
+}
+
+type ReleaseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Released      bool                   `protobuf:"varint,1,opt,name=released,proto3" json:"released,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseResponse) Reset() {
+	*x = ReleaseResponse{}
+	mi := &file_proto_limiter_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseResponse) ProtoMessage() {}
+
+func (x *ReleaseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_limiter_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseResponse.ProtoReflect.Descriptor instead.
+func (*ReleaseResponse) Descriptor() ([]byte, []int) {
+	return file_proto_limiter_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ReleaseResponse) GetReleased() bool {
+	if x != nil {
+		return x.Released
+	}
+	return false
+}
+
 var File_proto_limiter_proto protoreflect.FileDescriptor
 
 const file_proto_limiter_proto_rawDesc = "" +
 	"\n" +
-	"\x13proto/limiter.proto\x12\alimiter\"\xc4\x01\n" +
+	"\x13proto/limiter.proto\x12\alimiter\"\xa5\x04\n" +
 	"\fCheckRequest\x12\x18\n" +
 	"\aservice\x18\x01 \x01(\tR\aservice\x12\x10\n" +
 	"\x03api\x18\x02 \x01(\tR\x03api\x12\x0e\n" +
 	"\x02ip\x18\x03 \x01(\tR\x02ip\x12<\n" +
-	"\aheaders\x18\x04 \x03(\v2\".limiter.CheckRequest.HeadersEntryR\aheaders\x1a:\n" +
+	"\aheaders\x18\x04 \x03(\v2\".limiter.CheckRequest.HeadersEntryR\aheaders\x12\x12\n" +
+	"\x04cost\x18\x05 \x01(\x05R\x04cost\x12\x16\n" +
+	"\x06method\x18\x06 \x01(\tR\x06method\x12\x1b\n" +
+	"\tpeer_addr\x18\a \x01(\tR\bpeerAddr\x126\n" +
+	"\x05query\x18\b \x03(\v2 .limiter.CheckRequest.QueryEntryR\x05query\x12E\n" +
+	"\n" +
+	"attributes\x18\t \x03(\v2%.limiter.CheckRequest.AttributesEntryR\n" +
+	"attributes\x12\x1e\n" +
+	"\n" +
+	"refundable\x18\n" +
+	" \x01(\bR\n" +
+	"refundable\x1a:\n" +
 	"\fHeadersEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"b\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a8\n" +
+	"\n" +
+	"QueryEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a=\n" +
+	"\x0fAttributesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xe9\x02\n" +
 	"\rCheckResponse\x12\x18\n" +
 	"\aallowed\x18\x01 \x01(\bR\aallowed\x12\x1c\n" +
 	"\tremaining\x18\x02 \x01(\x05R\tremaining\x12\x19\n" +
-	"\breset_at\x18\x03 \x01(\x03R\aresetAt\"S\n" +
+	"\breset_at\x18\x03 \x01(\x03R\aresetAt\x12\x19\n" +
+	"\bdelay_ms\x18\x04 \x01(\x03R\adelayMs\x12%\n" +
+	"\x0ereservation_id\x18\x05 \x01(\tR\rreservationId\x12$\n" +
+	"\x0eretry_after_ms\x18\x06 \x01(\x03R\fretryAfterMs\x12\x14\n" +
+	"\x05limit\x18\a \x01(\x05R\x05limit\x12\x12\n" +
+	"\x04path\x18\b \x01(\tR\x04path\x12\x1c\n" +
+	"\talgorithm\x18\t \x01(\tR\talgorithm\x12!\n" +
+	"\fkey_strategy\x18\n" +
+	" \x01(\tR\vkeyStrategy\x12\x1a\n" +
+	"\bdegraded\x18\v \x01(\bR\bdegraded\x12\x16\n" +
+	"\x06reason\x18\f \x01(\tR\x06reason\"L\n" +
+	"\rRefundRequest\x12%\n" +
+	"\x0ereservation_id\x18\x01 \x01(\tR\rreservationId\x12\x14\n" +
+	"\x05units\x18\x02 \x01(\x05R\x05units\",\n" +
+	"\x0eRefundResponse\x12\x1a\n" +
+	"\brefunded\x18\x01 \x01(\x05R\brefunded\"S\n" +
 	"\x0fRegisterRequest\x12\x18\n" +
 	"\aservice\x18\x01 \x01(\tR\aservice\x12&\n" +
-	"\x04apis\x18\x02 \x03(\v2\x12.limiter.APIConfigR\x04apis\"\xb3\x01\n" +
+	"\x04apis\x18\x02 \x03(\v2\x12.limiter.APIConfigR\x04apis\"\x85\x02\n" +
 	"\tAPIConfig\x12\x12\n" +
 	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1c\n" +
 	"\talgorithm\x18\x02 \x01(\tR\talgorithm\x12!\n" +
 	"\fkey_strategy\x18\x03 \x01(\tR\vkeyStrategy\x12\x14\n" +
 	"\x05limit\x18\x04 \x01(\x05R\x05limit\x12%\n" +
 	"\x0ewindow_seconds\x18\x05 \x01(\x05R\rwindowSeconds\x12\x14\n" +
-	"\x05burst\x18\x06 \x01(\x05R\x05burst\"F\n" +
+	"\x05burst\x18\x06 \x01(\x05R\x05burst\x12\x1b\n" +
+	"\twindow_ms\x18\a \x01(\x03R\bwindowMs\x12\x19\n" +
+	"\bkey_path\x18\b \x01(\tR\akeyPath\x12\x18\n" +
+	"\amethods\x18\t \x03(\tR\amethods\"F\n" +
 	"\x10RegisterResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage2\x86\x01\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"\xb7\x01\n" +
+	"\x0fAcquireResponse\x12\x18\n" +
+	"\aallowed\x18\x01 \x01(\bR\aallowed\x12\x1c\n" +
+	"\tremaining\x18\x02 \x01(\x05R\tremaining\x12\x19\n" +
+	"\blease_id\x18\x03 \x01(\tR\aleaseId\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x04 \x01(\x03R\texpiresAt\x12\x1a\n" +
+	"\bdegraded\x18\x05 \x01(\bR\bdegraded\x12\x16\n" +
+	"\x06reason\x18\x06 \x01(\tR\x06reason\"+\n" +
+	"\x0eReleaseRequest\x12\x19\n" +
+	"\blease_id\x18\x01 \x01(\tR\aleaseId\"-\n" +
+	"\x0fReleaseResponse\x12\x1a\n" +
+	"\breleased\x18\x01 \x01(\bR\breleased2\xf2\x02\n" +
 	"\vRateLimiter\x126\n" +
-	"\x05Check\x12\x15.limiter.CheckRequest\x1a\x16.limiter.CheckResponse\x12?\n" +
-	"\bRegister\x12\x18.limiter.RegisterRequest\x1a\x19.limiter.RegisterResponseB%Z#github.com/larrasket/hlimiter/protob\x06proto3"
+	"\x05Check\x12\x15.limiter.CheckRequest\x1a\x16.limiter.CheckResponse\x125\n" +
+	"\x04Peek\x12\x15.limiter.CheckRequest\x1a\x16.limiter.CheckResponse\x129\n" +
+	"\x06Refund\x12\x16.limiter.RefundRequest\x1a\x17.limiter.RefundResponse\x12?\n" +
+	"\bRegister\x12\x18.limiter.RegisterRequest\x1a\x19.limiter.RegisterResponse\x12:\n" +
+	"\aAcquire\x12\x15.limiter.CheckRequest\x1a\x18.limiter.AcquireResponse\x12<\n" +
+	"\aRelease\x12\x17.limiter.ReleaseRequest\x1a\x18.limiter.ReleaseResponseB%Z#github.com/larrasket/hlimiter/protob\x06proto3"
 
 var (
 	file_proto_limiter_proto_rawDescOnce sync.Once
@@ -383,27 +847,44 @@ func file_proto_limiter_proto_rawDescGZIP() []byte {
 	return file_proto_limiter_proto_rawDescData
 }
 
-var file_proto_limiter_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_proto_limiter_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
 var file_proto_limiter_proto_goTypes = []any{
 	(*CheckRequest)(nil),     // 0: limiter.CheckRequest
 	(*CheckResponse)(nil),    // 1: limiter.CheckResponse
-	(*RegisterRequest)(nil),  // 2: limiter.RegisterRequest
-	(*APIConfig)(nil),        // 3: limiter.APIConfig
-	(*RegisterResponse)(nil), // 4: limiter.RegisterResponse
-	nil,                      // 5: limiter.CheckRequest.HeadersEntry
+	(*RefundRequest)(nil),    // 2: limiter.RefundRequest
+	(*RefundResponse)(nil),   // 3: limiter.RefundResponse
+	(*RegisterRequest)(nil),  // 4: limiter.RegisterRequest
+	(*APIConfig)(nil),        // 5: limiter.APIConfig
+	(*RegisterResponse)(nil), // 6: limiter.RegisterResponse
+	(*AcquireResponse)(nil),  // 7: limiter.AcquireResponse
+	(*ReleaseRequest)(nil),   // 8: limiter.ReleaseRequest
+	(*ReleaseResponse)(nil),  // 9: limiter.ReleaseResponse
+	nil,                      // 10: limiter.CheckRequest.HeadersEntry
+	nil,                      // 11: limiter.CheckRequest.QueryEntry
+	nil,                      // 12: limiter.CheckRequest.AttributesEntry
 }
 var file_proto_limiter_proto_depIdxs = []int32{
-	5, // 0: limiter.CheckRequest.headers:type_name -> limiter.CheckRequest.HeadersEntry
-	3, // 1: limiter.RegisterRequest.apis:type_name -> limiter.APIConfig
-	0, // 2: limiter.RateLimiter.Check:input_type -> limiter.CheckRequest
-	2, // 3: limiter.RateLimiter.Register:input_type -> limiter.RegisterRequest
-	1, // 4: limiter.RateLimiter.Check:output_type -> limiter.CheckResponse
-	4, // 5: limiter.RateLimiter.Register:output_type -> limiter.RegisterResponse
-	4, // [4:6] is the sub-list for method output_type
-	2, // [2:4] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	10, // 0: limiter.CheckRequest.headers:type_name -> limiter.CheckRequest.HeadersEntry
+	11, // 1: limiter.CheckRequest.query:type_name -> limiter.CheckRequest.QueryEntry
+	12, // 2: limiter.CheckRequest.attributes:type_name -> limiter.CheckRequest.AttributesEntry
+	5,  // 3: limiter.RegisterRequest.apis:type_name -> limiter.APIConfig
+	0,  // 4: limiter.RateLimiter.Check:input_type -> limiter.CheckRequest
+	0,  // 5: limiter.RateLimiter.Peek:input_type -> limiter.CheckRequest
+	2,  // 6: limiter.RateLimiter.Refund:input_type -> limiter.RefundRequest
+	4,  // 7: limiter.RateLimiter.Register:input_type -> limiter.RegisterRequest
+	0,  // 8: limiter.RateLimiter.Acquire:input_type -> limiter.CheckRequest
+	8,  // 9: limiter.RateLimiter.Release:input_type -> limiter.ReleaseRequest
+	1,  // 10: limiter.RateLimiter.Check:output_type -> limiter.CheckResponse
+	1,  // 11: limiter.RateLimiter.Peek:output_type -> limiter.CheckResponse
+	3,  // 12: limiter.RateLimiter.Refund:output_type -> limiter.RefundResponse
+	6,  // 13: limiter.RateLimiter.Register:output_type -> limiter.RegisterResponse
+	7,  // 14: limiter.RateLimiter.Acquire:output_type -> limiter.AcquireResponse
+	9,  // 15: limiter.RateLimiter.Release:output_type -> limiter.ReleaseResponse
+	10, // [10:16] is the sub-list for method output_type
+	4,  // [4:10] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_proto_limiter_proto_init() }
@@ -417,7 +898,7 @@ func file_proto_limiter_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_limiter_proto_rawDesc), len(file_proto_limiter_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   13,
 			NumExtensions: 0,
 			NumServices:   1,
 		},